@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yepher/SlackRollCall/conversations"
+)
+
+/**
+Read-only JSON API.
+
+	Served under /api/v1 on --serve when --apitoken is set. Every request
+	must send one of the tokens as "Authorization: Bearer <token>".
+
+	GET /api/v1/users                  ?email= ?name= ?active=true|false
+	GET /api/v1/users/{id}
+	GET /api/v1/channels               ?name=
+	GET /api/v1/channels/{id}
+	GET /api/v1/events                 ?since= ?until= ?type= ?user=
+	GET /api/v1/snapshots
+
+	Times are RFC 3339 or dates (2006-01-02). Users and channels come from
+	the caches, events from --eventlog.
+**/
+
+var apiTokens = []string{}
+
+// SnapshotInfo describes a cache file
+type SnapshotInfo struct {
+	File    string     `json:"file"`
+	Updated *time.Time `json:"updated,omitempty"`
+	Count   int        `json:"count"`
+	Active  int        `json:"active"`
+}
+
+// EventLogInfo describes the event log
+type EventLogInfo struct {
+	File  string     `json:"file"`
+	Count int        `json:"count"`
+	First *time.Time `json:"first,omitempty"`
+	Last  *time.Time `json:"last,omitempty"`
+}
+
+// SnapshotsResponse is the body of /api/v1/snapshots
+type SnapshotsResponse struct {
+	Members  *SnapshotInfo `json:"members,omitempty"`
+	Channels *SnapshotInfo `json:"channels,omitempty"`
+	Events   *EventLogInfo `json:"events,omitempty"`
+}
+
+// APIError is the body of every failed request
+type APIError struct {
+	Error string `json:"error"`
+}
+
+// newAPI routes the API on the mux
+func newAPI(mux *http.ServeMux) {
+	mux.Handle("/api/v1/users", apiAuth(apiUsers))
+	mux.Handle("/api/v1/users/", apiAuth(apiUser))
+	mux.Handle("/api/v1/channels", apiAuth(apiChannels))
+	mux.Handle("/api/v1/channels/", apiAuth(apiChannel))
+	mux.Handle("/api/v1/events", apiAuth(apiEvents))
+	mux.Handle("/api/v1/snapshots", apiAuth(apiSnapshots))
+}
+
+// apiAuth only lets GET requests with one of the API tokens through
+func apiAuth(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		switch {
+		case !validAPIToken(token):
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, http.StatusUnauthorized, "invalid or missing token")
+		case r.Method != http.MethodGet:
+			writeAPIError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		default:
+			handler(w, r)
+		}
+	})
+}
+
+// validAPIToken reports whether the token is one of the API tokens
+func validAPIToken(token string) bool {
+	valid := false
+	for _, apiToken := range apiTokens {
+		if apiToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func apiUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	users := []*User{}
+
+	if members := loadMembersFromFile(memberCacheFile); members != nil {
+		for _, member := range members.Members {
+			if email := query.Get("email"); email != "" && !strings.EqualFold(member.Profile.Email, email) {
+				continue
+			}
+			if name := query.Get("name"); name != "" && !memberNamed(member, name) {
+				continue
+			}
+			if active := query.Get("active"); active != "" && (active == "true") == member.Deleted {
+				continue
+			}
+			users = append(users, member)
+		}
+	}
+
+	writeAPIResponse(w, users)
+}
+
+func apiUser(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/users/")
+
+	if members := loadMembersFromFile(memberCacheFile); members != nil {
+		if member := findMember(id, members); member != nil {
+			writeAPIResponse(w, member)
+			return
+		}
+	}
+
+	writeAPIError(w, http.StatusNotFound, "no user "+id)
+}
+
+func apiChannels(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Query().Get("name"), "#")
+	found := []*conversations.Channel{}
+
+	if channels := conversations.LoadFromFile(channelCacheFile); channels != nil {
+		for _, channel := range channels.Channels {
+			if name == "" || strings.EqualFold(channel.Name, name) {
+				found = append(found, channel)
+			}
+		}
+	}
+
+	writeAPIResponse(w, found)
+}
+
+func apiChannel(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/channels/")
+
+	if channels := conversations.LoadFromFile(channelCacheFile); channels != nil {
+		if channel := conversations.Find(id, channels); channel != nil {
+			writeAPIResponse(w, channel)
+			return
+		}
+	}
+
+	writeAPIError(w, http.StatusNotFound, "no channel "+id)
+}
+
+func apiEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	since, err := parseAPITime(query.Get("since"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid since: "+err.Error())
+		return
+	}
+	until, err := parseAPITime(query.Get("until"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid until: "+err.Error())
+		return
+	}
+
+	events, err := loadEventLog()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	found := []*Event{}
+	for _, event := range events {
+		if !since.IsZero() && event.Time.Before(since) {
+			continue
+		}
+		if !until.IsZero() && !event.Time.Before(until) {
+			continue
+		}
+		if eventType := query.Get("type"); eventType != "" && event.Type != eventType {
+			continue
+		}
+		if user := query.Get("user"); user != "" && !event.involves(user) {
+			continue
+		}
+		found = append(found, event)
+	}
+
+	writeAPIResponse(w, found)
+}
+
+func apiSnapshots(w http.ResponseWriter, r *http.Request) {
+	response := &SnapshotsResponse{}
+
+	if memberCacheFile != "" {
+		response.Members = &SnapshotInfo{File: memberCacheFile, Updated: fileModified(memberCacheFile)}
+		if members := loadMembersFromFile(memberCacheFile); members != nil {
+			response.Members.Count = len(members.Members)
+			for _, member := range members.Members {
+				if !member.Deleted {
+					response.Members.Active++
+				}
+			}
+		}
+	}
+
+	if channelCacheFile != "" {
+		response.Channels = &SnapshotInfo{File: channelCacheFile, Updated: fileModified(channelCacheFile)}
+		if channels := conversations.LoadFromFile(channelCacheFile); channels != nil {
+			response.Channels.Count = len(channels.Channels)
+			for _, channel := range channels.Channels {
+				if !channel.IsArchived {
+					response.Channels.Active++
+				}
+			}
+		}
+	}
+
+	if eventLogFile != "" {
+		events, err := loadEventLog()
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response.Events = &EventLogInfo{File: eventLogFile, Count: len(events)}
+		if len(events) > 0 {
+			response.Events.First = &events[0].Time
+			response.Events.Last = &events[len(events)-1].Time
+		}
+	}
+
+	writeAPIResponse(w, response)
+}
+
+// memberNamed reports whether the name is the member's username or real name
+func memberNamed(member *User, name string) bool {
+	name = strings.TrimPrefix(name, "@")
+	for _, candidate := range []string{member.Name, member.RealName, member.Profile.RealName} {
+		if candidate != "" && strings.EqualFold(candidate, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAPITime parses an RFC 3339 time or a date, returning the zero time for an empty value
+func parseAPITime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+func fileModified(file string) *time.Time {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil
+	}
+	modified := info.ModTime().UTC()
+	return &modified
+}
+
+func writeAPIResponse(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&APIError{message})
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/yepher/SlackRollCall/options"
+)
+
+/**
+Acknowledgement of vetted suspect members.
+
+	Once security has reviewed a flagged member, acknowledge them with
+
+		SlackRollCall ack add --acks ./acks.json --user U0123ABCD --by alice --expires 90d
+
+	and pass --acks ./acks.json to later runs: the member is no longer reported
+	as a suspect, whatever their email or profile changes to, until the
+	acknowledgement expires or is removed with ack remove.
+
+Interactivity: https://api.slack.com/interactivity/handling
+	With --ackbuttons "true" the Slack channel report is followed by an
+	Acknowledge button per suspect member. Point the Slack app's interactivity
+	request URL at ack listen to record the clicks. With --ackreviewers only
+	the listed users, or members of the listed user groups, may acknowledge;
+	anyone else clicking gets an error only they can see.
+**/
+
+// Ack records who reviewed a member, and when
+type Ack struct {
+	UserID   string     `json:"user_id"`
+	Name     string     `json:"name,omitempty"`
+	Reviewer string     `json:"reviewer"`
+	Note     string     `json:"note,omitempty"`
+	Time     time.Time  `json:"time"`
+	Expires  *time.Time `json:"expires,omitempty"`
+}
+
+// ackActionID identifies the Acknowledge button in interaction payloads
+const ackActionID = "rollcall_ack"
+
+// slackRequestMaxAge is how old a signed Slack request may be
+const slackRequestMaxAge = 5 * time.Minute
+
+var ackFile = ""
+var ackReviewers = []string{}
+var acks = map[string]*Ack{}
+var ackLock sync.Mutex
+
+var ackFileFlag = cli.StringFlag{
+	Name:  "acks, f",
+	Value: "./acks.json",
+	Usage: "Optional, acknowledgement file",
+}
+
+var ackCommand = cli.Command{
+	Name:  "ack",
+	Usage: "Acknowledge vetted suspect members so they are no longer reported",
+	Subcommands: []cli.Command{
+		{
+			Name:  "add",
+			Usage: "Acknowledge a member",
+			Flags: []cli.Flag{
+				ackFileFlag,
+				cli.StringFlag{Name: "user", Usage: "Required, member ID"},
+				cli.StringFlag{Name: "name", Usage: "Optional, member name for the record"},
+				cli.StringFlag{Name: "by", Value: os.Getenv("USER"), Usage: "Optional, reviewer"},
+				cli.StringFlag{Name: "note", Usage: "Optional, review note"},
+				cli.StringFlag{Name: "expires", Usage: "Optional, how long the acknowledgement lasts, e.g. 72h or 90d"},
+			},
+			Action: func(c *cli.Context) {
+				if c.String("user") == "" || c.String("by") == "" {
+					fmt.Printf("\n\nError: --user and --by must be set\n\n")
+					os.Exit(1)
+				}
+
+				expires, err := parseExpiry(c.String("expires"))
+				if err == nil {
+					err = addAck(c.String("acks"), &Ack{
+						UserID:   c.String("user"),
+						Name:     c.String("name"),
+						Reviewer: c.String("by"),
+						Note:     c.String("note"),
+						Time:     time.Now().UTC(),
+						Expires:  expires,
+					})
+				}
+				if err != nil {
+					fmt.Printf("\n\nError: %v\n\n", err)
+					os.Exit(1)
+				}
+				fmt.Printf("Acknowledged %s\n", c.String("user"))
+			},
+		},
+		{
+			Name:  "remove",
+			Usage: "Remove a member's acknowledgement",
+			Flags: []cli.Flag{
+				ackFileFlag,
+				cli.StringFlag{Name: "user", Usage: "Required, member ID"},
+			},
+			Action: func(c *cli.Context) {
+				if err := removeAck(c.String("acks"), c.String("user")); err != nil {
+					fmt.Printf("\n\nError: %v\n\n", err)
+					os.Exit(1)
+				}
+				fmt.Printf("Removed %s\n", c.String("user"))
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "List the acknowledged members",
+			Flags: []cli.Flag{ackFileFlag},
+			Action: func(c *cli.Context) {
+				loaded, err := loadAcks(c.String("acks"))
+				if err != nil {
+					fmt.Printf("\n\nError: %v\n\n", err)
+					os.Exit(1)
+				}
+
+				ids := []string{}
+				for id := range loaded {
+					ids = append(ids, id)
+				}
+				sort.Strings(ids)
+
+				for _, id := range ids {
+					ack := loaded[id]
+					expires := "never"
+					if ack.Expires != nil {
+						expires = ack.Expires.Format(time.RFC3339)
+					}
+					fmt.Printf("%s\t%s\tby %s on %s, expires %s\t%s\n", ack.UserID, ack.Name, ack.Reviewer,
+						ack.Time.Format(time.RFC3339), expires, ack.Note)
+				}
+			},
+		},
+		{
+			Name:  "listen",
+			Usage: "Record acknowledgements from the Slack Acknowledge buttons",
+			Flags: []cli.Flag{
+				ackFileFlag,
+				cli.StringFlag{Name: "listen", Value: ":8080", Usage: "Optional, address to listen on"},
+				cli.StringFlag{Name: "signingsecret", EnvVar: "SLACK_SIGNING_SECRET", Usage: "Required, Slack app signing secret"},
+				cli.StringFlag{Name: "expires", Usage: "Optional, how long button acknowledgements last, e.g. 90d"},
+				cli.StringFlag{Name: "reviewers", Usage: "Optional, comma separated user or user group IDs allowed to acknowledge, anyone when empty"},
+				cli.StringFlag{Name: "apikey, k", EnvVar: "SLACK_API_KEY", Usage: "Optional, Slack API key to look up the members of reviewer user groups"},
+			},
+			Action: func(c *cli.Context) {
+				apiKey = c.String("apikey")
+				handler, err := newAckHandler(c.String("acks"), c.String("signingsecret"), c.String("expires"), options.List(c.String("reviewers")))
+				if err == nil {
+					slog.Info("listening for acknowledgements", "address", c.String("listen"))
+					err = http.ListenAndServe(c.String("listen"), handler)
+				}
+				if err != nil {
+					fmt.Printf("\n\nError: %v\n\n", err)
+					os.Exit(1)
+				}
+			},
+		},
+	},
+}
+
+// acknowledged returns the member's current acknowledgement, if any
+func acknowledged(user *User) *Ack {
+	if user == nil {
+		return nil
+	}
+
+	ack, ok := acks[user.ID]
+	if !ok || (ack.Expires != nil && time.Now().After(*ack.Expires)) {
+		return nil
+	}
+	return ack
+}
+
+// reloadAcks picks up acknowledgements added since the file was loaded
+func reloadAcks() error {
+	if ackFile == "" {
+		return nil
+	}
+
+	loaded, err := loadAcks(ackFile)
+	if err != nil {
+		return err
+	}
+	acks = loaded
+	return nil
+}
+
+// filterAcknowledged drops the suspect events of acknowledged members
+func filterAcknowledged(events []*Event) []*Event {
+	kept := []*Event{}
+	for _, event := range events {
+		if ack := acknowledged(event.User); ack != nil && event.Type == EventSuspectMember {
+			slog.Info("acknowledged", "id", event.User.ID, "reviewer", ack.Reviewer)
+			continue
+		}
+		kept = append(kept, event)
+	}
+	return kept
+}
+
+// parseExpiry converts a duration such as 72h or 90d to an expiry time,
+// returning nil for an empty duration
+func parseExpiry(duration string) (*time.Time, error) {
+	if duration == "" {
+		return nil, nil
+	}
+
+	var length time.Duration
+	if strings.HasSuffix(duration, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(duration, "d"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry %q", duration)
+		}
+		length = time.Duration(days) * 24 * time.Hour
+	} else {
+		var err error
+		if length, err = time.ParseDuration(duration); err != nil {
+			return nil, fmt.Errorf("invalid expiry %q", duration)
+		}
+	}
+
+	expires := time.Now().UTC().Add(length)
+	return &expires, nil
+}
+
+func loadAcks(file string) (map[string]*Ack, error) {
+	loaded := map[string]*Ack{}
+
+	contents, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return loaded, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	list := []*Ack{}
+	if err = json.Unmarshal(contents, &list); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	for _, ack := range list {
+		loaded[ack.UserID] = ack
+	}
+
+	return loaded, nil
+}
+
+func saveAcks(file string, saved map[string]*Ack) error {
+	list := []*Ack{}
+	for _, ack := range saved {
+		list = append(list, ack)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UserID < list[j].UserID })
+
+	contents, err := json.MarshalIndent(list, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, contents, 0644)
+}
+
+func addAck(file string, ack *Ack) error {
+	ackLock.Lock()
+	defer ackLock.Unlock()
+
+	loaded, err := loadAcks(file)
+	if err != nil {
+		return err
+	}
+	loaded[ack.UserID] = ack
+	return saveAcks(file, loaded)
+}
+
+func removeAck(file string, userID string) error {
+	ackLock.Lock()
+	defer ackLock.Unlock()
+
+	loaded, err := loadAcks(file)
+	if err != nil {
+		return err
+	}
+	if _, ok := loaded[userID]; !ok {
+		return fmt.Errorf("%s is not acknowledged", userID)
+	}
+	delete(loaded, userID)
+	return saveAcks(file, loaded)
+}
+
+// ackBlocks builds a section with an Acknowledge button for every suspect member
+func ackBlocks(events []*Event) []interface{} {
+	blocks := []interface{}{}
+	for _, event := range events {
+		if event.Type != EventSuspectMember || event.User == nil {
+			continue
+		}
+
+		text := fmt.Sprintf("*%s* %s\n%s", displayName(event.User), event.User.Profile.Email, strings.Join(event.Reasons, ", "))
+		blocks = append(blocks, map[string]interface{}{
+			"type": "section",
+			"text": map[string]string{"type": "mrkdwn", "text": text},
+			"accessory": map[string]interface{}{
+				"type":      "button",
+				"text":      map[string]string{"type": "plain_text", "text": "Acknowledge"},
+				"action_id": ackActionID,
+				"value":     event.User.ID,
+			},
+		})
+	}
+	return blocks
+}
+
+// SlackInteraction is the part of a block_actions payload used to record acknowledgements
+type SlackInteraction struct {
+	Type string `json:"type"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+	ResponseURL string `json:"response_url"`
+}
+
+// SlackUserGroupMembers is the usergroups.users.list response
+type SlackUserGroupMembers struct {
+	SlackResponse
+	Users []string `json:"users"`
+}
+
+type ackHandler struct {
+	file          string
+	signingSecret string
+	expires       string
+	reviewers     []string
+}
+
+func newAckHandler(file string, signingSecret string, expires string, reviewers []string) (http.Handler, error) {
+	if signingSecret == "" {
+		return nil, fmt.Errorf("a Slack signing secret must be set")
+	}
+	if _, err := parseExpiry(expires); err != nil {
+		return nil, err
+	}
+	if err := checkAckReviewers(reviewers); err != nil {
+		return nil, err
+	}
+	return &ackHandler{file, signingSecret, expires, reviewers}, nil
+}
+
+// checkAckReviewers makes sure every reviewer is a user or user group ID
+func checkAckReviewers(reviewers []string) error {
+	for _, reviewer := range reviewers {
+		if !slackIDPattern.MatchString(reviewer) {
+			return fmt.Errorf("reviewer %q is not a user or user group ID", reviewer)
+		}
+	}
+	return nil
+}
+
+// ServeHTTP records an acknowledgement for every Acknowledge button click
+func (handler *ackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "unreadable request", http.StatusBadRequest)
+		return
+	}
+
+	if !verifySlackSignature(handler.signingSecret, r.Header, body) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	var interaction SlackInteraction
+	if err = json.Unmarshal([]byte(form.Get("payload")), &interaction); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	if err = recordAckInteraction(handler.file, handler.expires, handler.reviewers, &interaction); err != nil {
+		slog.Error("recording acknowledgement", "error", err)
+		http.Error(w, "could not record acknowledgement", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// recordAckInteraction records an acknowledgement for every Acknowledge
+// button clicked in the interaction, when the user clicking is one of the
+// reviewers
+func recordAckInteraction(file string, expiry string, reviewers []string, interaction *SlackInteraction) error {
+	for _, action := range interaction.Actions {
+		if action.ActionID != ackActionID {
+			continue
+		}
+
+		allowed, err := isAckReviewer(interaction.User.ID, reviewers)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			slog.Warn("acknowledgement refused", "id", action.Value, "user", interaction.User.ID)
+			if interaction.ResponseURL != "" {
+				postJSON(interaction.ResponseURL, map[string]interface{}{
+					"response_type":    "ephemeral",
+					"replace_original": false,
+					"text":             "You are not one of the reviewers allowed to acknowledge members.",
+				})
+			}
+			return nil
+		}
+
+		expires, _ := parseExpiry(expiry)
+		err = addAck(file, &Ack{
+			UserID:   action.Value,
+			Reviewer: interaction.User.Username,
+			Note:     "acknowledged in Slack by " + interaction.User.ID,
+			Time:     time.Now().UTC(),
+			Expires:  expires,
+		})
+		if err != nil {
+			return err
+		}
+
+		slog.Info("acknowledged from Slack", "id", action.Value, "reviewer", interaction.User.Username)
+		if interaction.ResponseURL != "" {
+			postJSON(interaction.ResponseURL, map[string]interface{}{
+				"replace_original": false,
+				"text":             fmt.Sprintf("<@%s> acknowledged <@%s>", interaction.User.ID, action.Value),
+			})
+		}
+	}
+
+	return nil
+}
+
+// isAckReviewer reports whether the user is one of the reviewers or in one of
+// their user groups. Anyone may acknowledge when there are no reviewers.
+func isAckReviewer(userID string, reviewers []string) (bool, error) {
+	if len(reviewers) == 0 {
+		return true, nil
+	}
+
+	for _, reviewer := range reviewers {
+		if reviewer == userID {
+			return true, nil
+		}
+		if reviewer[0] != 'S' {
+			continue
+		}
+
+		members, err := loadUserGroupMembers(reviewer)
+		if err != nil {
+			return false, err
+		}
+		for _, member := range members {
+			if member == userID {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// loadUserGroupMembers returns the IDs of the members of a user group
+func loadUserGroupMembers(group string) ([]string, error) {
+	req, err := http.NewRequest("GET", slackAPIURL+"usergroups.users.list?usergroup="+url.QueryEscape(group), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", "Bearer "+apiKey)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	contents, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var members SlackUserGroupMembers
+	if err = json.Unmarshal(contents, &members); err != nil {
+		return nil, err
+	}
+	if !members.Ok {
+		return nil, fmt.Errorf("usergroups.users.list %s: %s", group, members.Error)
+	}
+
+	return members.Users, nil
+}
+
+// verifySlackSignature checks the X-Slack-Signature of a request against the signing secret
+func verifySlackSignature(secret string, header http.Header, body []byte) bool {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	age := time.Since(time.Unix(seconds, 0))
+	if age > slackRequestMaxAge || age < -slackRequestMaxAge {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature")))
+}
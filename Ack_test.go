@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slackSignature signs a request body the way Slack does
+func slackSignature(secret string, timestamp string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySlackSignature(t *testing.T) {
+	secret := "8f742231b10e8888abcd99yyyzzz85a5"
+	body := "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&command=%2Fwebhook-collect"
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-6*time.Minute).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(6*time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		body      string
+		valid     bool
+	}{
+		{"signed", now, slackSignature(secret, now, body), body, true},
+		{"other secret", now, slackSignature("other", now, body), body, false},
+		{"changed body", now, slackSignature(secret, now, body), body + "&text=x", false},
+		{"changed timestamp", now, slackSignature(secret, stale, body), body, false},
+		{"uppercase signature", now, strings.ToUpper(slackSignature(secret, now, body)), body, false},
+		{"stale", stale, slackSignature(secret, stale, body), body, false},
+		{"from the future", future, slackSignature(secret, future, body), body, false},
+		{"no timestamp", "", slackSignature(secret, "", body), body, false},
+		{"bad timestamp", "soon", slackSignature(secret, "soon", body), body, false},
+		{"no signature", now, "", body, false},
+		// The example from Slack's documentation, long expired
+		{"replayed", "1531420618", "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503", body, false},
+	}
+
+	for _, test := range tests {
+		header := http.Header{}
+		header.Set("X-Slack-Request-Timestamp", test.timestamp)
+		header.Set("X-Slack-Signature", test.signature)
+		if valid := verifySlackSignature(secret, header, []byte(test.body)); valid != test.valid {
+			t.Errorf("%s: got %v, want %v", test.name, valid, test.valid)
+		}
+	}
+}
+
+func TestFilterAcknowledged(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "acks.json")
+	expired := time.Now().Add(-time.Hour)
+	if err := addAck(file, &Ack{UserID: "U1", Reviewer: "ann", Time: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := addAck(file, &Ack{UserID: "U2", Reviewer: "ann", Time: time.Now(), Expires: &expired}); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	if acks, err = loadAcks(file); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { acks = map[string]*Ack{} })
+
+	events := []*Event{
+		newMemberEvent(EventSuspectMember, &User{ID: "U1"}, nil, "acknowledged"),
+		newMemberEvent(EventSuspectMember, &User{ID: "U2"}, nil, "expired"),
+		newMemberEvent(EventMemberJoined, &User{ID: "U1"}, nil, "not a suspect"),
+	}
+	kept := filterAcknowledged(events)
+	if len(kept) != 2 || kept[0].Text != "expired" || kept[1].Text != "not a suspect" {
+		t.Fatalf("got %d events, want the acknowledged suspect dropped", len(kept))
+	}
+
+	if err = removeAck(file, "U9"); err == nil {
+		t.Fatal("removed an acknowledgement that does not exist")
+	}
+	if err = removeAck(file, "U1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAckHandler(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "acks.json")
+	handler, err := newAckHandler(file, "secret", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := "payload=" + url.QueryEscape(`{"type":"block_actions","user":{"id":"UR","username":"rev"},"actions":[{"action_id":"rollcall_ack","value":"U7"}]}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	tests := []struct {
+		name      string
+		signature string
+		status    int
+	}{
+		{"forged", "v0=00", http.StatusUnauthorized},
+		{"signed", slackSignature("secret", now, body), http.StatusOK},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest("POST", "/slack/interactions", strings.NewReader(body))
+		request.Header.Set("X-Slack-Request-Timestamp", now)
+		request.Header.Set("X-Slack-Signature", test.signature)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+
+		if response.Code != test.status {
+			t.Fatalf("%s: got status %d, want %d", test.name, response.Code, test.status)
+		}
+
+		saved, _ := loadAcks(file)
+		if recorded := saved["U7"] != nil; recorded != (test.status == http.StatusOK) {
+			t.Fatalf("%s: recorded is %v", test.name, recorded)
+		}
+	}
+
+	saved, _ := loadAcks(file)
+	if saved["U7"].Reviewer != "rev" {
+		t.Fatalf("got reviewer %q", saved["U7"].Reviewer)
+	}
+}
+
+func TestAckReviewers(t *testing.T) {
+	newSlackStandIn(t, map[string]interface{}{
+		"usergroups.users.list": &SlackUserGroupMembers{SlackResponse{Ok: true}, []string{"U2"}},
+	})
+
+	replies := []string{}
+	responses := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		replies = append(replies, string(body))
+	}))
+	defer responses.Close()
+
+	tests := []struct {
+		user      string
+		reviewers []string
+		recorded  bool
+	}{
+		{"U1", nil, true},
+		{"U1", []string{"U1", "S1"}, true},
+		{"U2", []string{"U1", "S1"}, true},
+		{"U3", []string{"U1", "S1"}, false},
+		{"U2", []string{"U1"}, false},
+	}
+
+	for _, test := range tests {
+		file := filepath.Join(t.TempDir(), "acks.json")
+		replies = replies[:0]
+
+		interaction := &SlackInteraction{ResponseURL: responses.URL}
+		interaction.User.ID = test.user
+		interaction.Actions = append(interaction.Actions, struct {
+			ActionID string `json:"action_id"`
+			Value    string `json:"value"`
+		}{ackActionID, "U7"})
+
+		if err := recordAckInteraction(file, "", test.reviewers, interaction); err != nil {
+			t.Fatalf("%s by %v: %v", test.user, test.reviewers, err)
+		}
+
+		saved, _ := loadAcks(file)
+		if recorded := saved["U7"] != nil; recorded != test.recorded {
+			t.Errorf("%s by %v: recorded is %v", test.user, test.reviewers, recorded)
+		}
+		if refused := len(replies) == 1 && strings.Contains(replies[0], `"ephemeral"`); refused == test.recorded {
+			t.Errorf("%s by %v: got replies %v", test.user, test.reviewers, replies)
+		}
+	}
+
+	if err := checkAckReviewers([]string{"U1", "alice"}); err == nil {
+		t.Error("a reviewer that is not an ID was accepted")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/yepher/SlackRollCall/options"
+)
+
+/**
+Allowlist mode flags every new non-bot member whose email domain is not
+approved. Entries use the same domain syntax as --monitor: an exact domain
+or *.domain for its subdomains. Guests (single and multi-channel) are
+checked against their own list when one is given.
+**/
+
+var allowed = []string{}
+var guestAllowed = []string{}
+
+func parseDomainList(list string) []string {
+	domains := []string{}
+	for _, domain := range options.List(list) {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+func isAllowed(user *User) bool {
+	if user.IsBot || user.Deleted || user.ID == "USLACKBOT" {
+		return true
+	}
+
+	domains := allowed
+	if (user.IsRestricted || user.IsUltraRestricted) && len(guestAllowed) > 0 {
+		domains = guestAllowed
+	}
+
+	if len(domains) == 0 {
+		return true
+	}
+
+	domain := emailDomain(user.Profile.Email)
+	for _, pattern := range domains {
+		if domainMatches(domain, pattern) {
+			return true
+		}
+	}
+
+	slog.Info("not from an approved domain", "id", user.ID, "email", redactEmail(user.Profile.Email))
+	return false
+}
@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestIsAllowed(t *testing.T) {
+	guest := func(user *User) *User {
+		user.IsRestricted = true
+		return user
+	}
+	channelGuest := func(user *User) *User {
+		user.IsUltraRestricted = true
+		return user
+	}
+	bot := &User{ID: "B1", Name: "deploybot", IsBot: true}
+	bot.Profile.Email = "bot@gmail.com"
+
+	tests := []struct {
+		name    string
+		allow   string
+		guests  string
+		user    *User
+		allowed bool
+	}{
+		{"no allowlist", "", "", testUser("U1", "ann", "ann@gmail.com"), true},
+		{"approved", "ourcompany.com", "", testUser("U1", "ann", "ann@ourcompany.com"), true},
+		{"approved any case", "@OurCompany.com", "", testUser("U1", "ann", "ann@OURCOMPANY.COM"), true},
+		{"unapproved", "ourcompany.com", "", testUser("U1", "ann", "ann@gmail.com"), false},
+		{"lookalike", "ourcompany.com", "", testUser("U1", "ann", "ann@notourcompany.com"), false},
+		{"no email", "ourcompany.com", "", testUser("U1", "ann", ""), false},
+		{"subdomain", "ourcompany.com, *.partner.io", "", testUser("U1", "ann", "ann@eu.partner.io"), true},
+		{"bare wildcard domain", "*.partner.io", "", testUser("U1", "ann", "ann@partner.io"), false},
+		{"bot", "ourcompany.com", "", bot, true},
+		{"slackbot", "ourcompany.com", "", &User{ID: "USLACKBOT"}, true},
+		{"guest without a guest list", "ourcompany.com", "", guest(testUser("U1", "ann", "ann@ourcompany.com")), true},
+		{"guest from a partner", "ourcompany.com", "partner.com", guest(testUser("U1", "ann", "ann@partner.com")), true},
+		{"guest from the company", "ourcompany.com", "partner.com", guest(testUser("U1", "ann", "ann@ourcompany.com")), false},
+		{"single channel guest", "ourcompany.com", "partner.com", channelGuest(testUser("U1", "ann", "ann@gmail.com")), false},
+		{"member from a partner", "ourcompany.com", "partner.com", testUser("U1", "ann", "ann@partner.com"), false},
+		{"guests only", "", "partner.com", testUser("U1", "ann", "ann@gmail.com"), true},
+	}
+
+	t.Cleanup(func() { allowed, guestAllowed = []string{}, []string{} })
+
+	for _, test := range tests {
+		allowed, guestAllowed = parseDomainList(test.allow), parseDomainList(test.guests)
+		if isAllowed(test.user) != test.allowed {
+			t.Errorf("%s: allowed is %v", test.name, !test.allowed)
+		}
+	}
+}
+
+func TestAllowlistReportsSuspects(t *testing.T) {
+	allowed = parseDomainList("ourcompany.com")
+	t.Cleanup(func() { allowed = []string{} })
+
+	staff := testUser("U1", "ann", "ann@ourcompany.com")
+	previous := &MemberList{Ok: true, Members: []*User{staff}}
+	current := &MemberList{Ok: true, Members: []*User{
+		testUser("U1", "ann", "ann@gmail.com"),
+		testUser("U2", "bob", "bob@ourcompany.com"),
+		testUser("U3", "eve", "eve@evil.io"),
+	}}
+
+	suspects := []string{}
+	for _, event := range diffMembers(previous, current) {
+		if event.Type == EventSuspectMember || event.Type == EventEmailChanged {
+			for _, reason := range event.Reasons {
+				if reason == "unapproved domain" {
+					suspects = append(suspects, event.User.ID)
+				}
+			}
+		}
+	}
+
+	if !sameNames(suspects, []string{"U1", "U3"}) {
+		t.Fatalf("got %v flagged, want the changed email and the new member outside the allowlist", suspects)
+	}
+}
@@ -4,13 +4,17 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"os"
-	"strings"
 
 	"github.com/codegangsta/cli"
+	"github.com/yepher/SlackRollCall/cache"
+	"github.com/yepher/SlackRollCall/conversations"
+	"github.com/yepher/SlackRollCall/logging"
+	"github.com/yepher/SlackRollCall/options"
+	"github.com/yepher/SlackRollCall/schedule"
 )
 
 /**
@@ -26,46 +30,6 @@ var channel = ""
 
 var ignorePrefixes []string
 
-// Channel contains all the information of a channel
-type Channel struct {
-	ID             string   `json:"id"`
-	Name           string   `json:"name"`
-	IsChannel      bool     `json:"is_channel"`
-	Created        int      `json:"created"`
-	Creator        string   `json:"creator"`
-	IsArchived     bool     `json:"is_archived"`
-	IsGeneral      bool     `json:"is_general"`
-	NameNormalized string   `json:"name_normalized"`
-	IsShared       bool     `json:"is_shared"`
-	IsOrgShared    bool     `json:"is_org_shared"`
-	IsMember       bool     `json:"is_member"`
-	IsPrivate      bool     `json:"is_private"`
-	IsMpim         bool     `json:"is_mpim"`
-	Members        []string `json:"members"`
-	Topic          struct {
-		Value   string `json:"value"`
-		Creator string `json:"creator"`
-		LastSet int    `json:"last_set"`
-	} `json:"topic"`
-	Purpose struct {
-		Value   string `json:"value"`
-		Creator string `json:"creator"`
-		LastSet int    `json:"last_set"`
-	} `json:"purpose"`
-	PreviousNames []interface{} `json:"previous_names"`
-	NumMembers    int           `json:"num_members"`
-}
-
-// ChannelList - TODO: comment this exported structure
-type ChannelList struct {
-	Ok               bool       `json:"ok"`
-	Channels         []*Channel `json:"channels,omitempty"`
-	CacheTimestamp   uint64     `json:"cache_ts"`
-	ResponseMetadata struct {
-		NextCursor string `json:"next_cursor"`
-	} `json:"response_metadata"`
-}
-
 type SlackMessage struct {
 	Channel string `json:"channel"`
 	Text    string `json:"text"`
@@ -87,7 +51,17 @@ func main() {
 		cli.StringFlag{
 			Name:  "verbose",
 			Value: "false",
-			Usage: "Dumps additional information to console",
+			Usage: "Logs debug information, same as --loglevel debug",
+		},
+		cli.StringFlag{
+			Name:  "loglevel",
+			Value: "info",
+			Usage: "Optional, least severe log messages written to stderr: debug, info, warn or error",
+		},
+		cli.StringFlag{
+			Name:  "logformat",
+			Value: "text",
+			Usage: "Optional, log format: text or json",
 		},
 		cli.StringFlag{
 			Name:  "cache, c",
@@ -108,6 +82,11 @@ func main() {
 			Value: "",
 			Usage: "Optional, Ignore channels with these prefixes.",
 		},
+		cli.StringFlag{
+			Name:  "watch, w",
+			Value: "",
+			Usage: "Optional, keep running and check on a schedule: an interval such as 15m or a cron expression such as \"*/15 * * * *\"",
+		},
 	}
 	app.Action = func(c *cli.Context) {
 		if c.String("apikey") == "" {
@@ -125,14 +104,24 @@ func main() {
 			isVerbose = true
 		}
 
+		logLevel := c.String("loglevel")
+		if isVerbose {
+			logLevel = "debug"
+		}
+		if err := logging.Setup(c.String("logformat"), logLevel); err != nil {
+			fmt.Printf("\n\nError: %v\n\n", err)
+
+			cli.ShowAppHelp(c)
+			return
+		}
+
 		if c.String("updatecache") == "true" {
 			saveCache = true
 		}
 
 		if c.String("ignore") != "" {
-			ignorePrefixes = strings.Split(c.String("ignore"), ",")
-			fmt.Print("Will ignore: ")
-			fmt.Println(ignorePrefixes)
+			ignorePrefixes = options.List(c.String("ignore"))
+			slog.Info("ignoring channels", "prefixes", ignorePrefixes)
 		}
 
 		// monitorString := c.String("monitor")
@@ -143,73 +132,89 @@ func main() {
 
 		channel = c.String("channel")
 
-		dumpDelta(c.String("cache"))
+		if c.String("watch") == "" {
+			if err := dumpDelta(c.String("cache")); err != nil {
+				slog.Error("run failed", "error", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		runs, err := schedule.Parse(c.String("watch"))
+		if err != nil {
+			fmt.Printf("\n\nError: --watch: %v\n\n", err)
+
+			cli.ShowAppHelp(c)
+			return
+		}
+
+		// Every run compares against the previous one
+		saveCache = true
+
+		schedule.Watch(runs, func() error {
+			return dumpDelta(c.String("cache"))
+		})
 	}
 	app.Run(os.Args)
 }
 
-func dumpDelta(fileName string) {
+func dumpDelta(fileName string) error {
 	var hasChanges = false
 	var result = ""
 
-	var channelList = loadChannelsFromFile(fileName)
+	var channelList = conversations.LoadFromFile(fileName)
 	if channelList == nil {
-		result = fmt.Sprintf("%sNo channel list cached. Will create one\n", result)
-		channelList := loadChannelList()
+		slog.Info("no channel list cached, will create one", "file", fileName)
+		channelList, err := conversations.Load(apiKey)
+		if err != nil {
+			return err
+		}
 		json, _ := json.Marshal(channelList)
 		writeCache(fileName, json)
 
-		return
+		return nil
 	}
 
-	var channelList2 = loadChannelList()
+	channelList2, err := conversations.Load(apiKey)
+	if err != nil {
+		return err
+	}
 
-	result = fmt.Sprintf("%s\n", result)
+	changes := conversations.Diff(channelList, channelList2, ignorePrefixes)
 
-	// Search for missing members
-	for _, element := range channelList.Channels {
-		channel := findChannel(element.ID, channelList2)
-		if channel == nil {
-			if !isIgnored(element) {
-				hasChanges = true
-				description := getDescription(element)
-				result = fmt.Sprintf("%s\t--- Removed Channel `%s` - %s\n", result, element.Name, description)
-			}
-		} else if channel.IsArchived != element.IsArchived {
-			isDelete := "no"
-			//fmt.Printf("%s changed states from ", element.Name, channel.IsArchived, element.IsArchived)
-			if channel.IsArchived {
-				isDelete = "YES"
-			}
+	result = fmt.Sprintf("%s\n", result)
 
-			if !isIgnored(element) {
-				hasChanges = true
-				description := getDescription(element)
-				result = fmt.Sprintf("%s\t*** Channel Changed, %s, %s, isDelete: %s\n", result, element.Name, description, isDelete)
-			}
+	// Removed, archived and renamed channels
+	for _, change := range changes {
+		element := change.Channel
+		description := conversations.Description(element)
+		switch change.Type {
+		case conversations.Removed:
+			result = fmt.Sprintf("%s\t--- Removed Channel `%s` - %s\n", result, element.Name, description)
+		case conversations.Archived:
+			result = fmt.Sprintf("%s\t*** Channel Changed, %s, %s, isDelete: YES\n", result, element.Name, description)
+		case conversations.Unarchived:
+			result = fmt.Sprintf("%s\t*** Channel Changed, %s, %s, isDelete: no\n", result, element.Name, description)
+		case conversations.Renamed:
+			result = fmt.Sprintf("%s\t*** Channel Renamed, %s -> <#%s> - %s\n", result, change.Previous.Name, element.ID, description)
+		default:
+			continue
 		}
+		hasChanges = true
 	}
 
 	result = fmt.Sprintf("%sSearching for new channels\n", result)
 
-	// Search for new members
-	//hasMonitoredEntries := false
-	//monitoredEntries := "Searching for monitored members @everyone WARNING possible bad actor(s) joined.\n*Please verify these users:*\n"
-
-	for _, element := range channelList2.Channels {
-		channel := findChannel(element.ID, channelList)
-		if channel == nil {
-
-			if !isIgnored(element) {
-				hasChanges = true
-				description := getDescription(element)
-				result = fmt.Sprintf("%s\t+++ Added Channel, <#%s> - %s \n", result, element.ID, description)
-			}
+	for _, change := range changes {
+		if change.Type == conversations.Created {
+			hasChanges = true
+			description := conversations.Description(change.Channel)
+			result = fmt.Sprintf("%s\t+++ Added Channel, <#%s> - %s \n", result, change.Channel.ID, description)
 		}
 	}
 
 	if saveCache {
-		fmt.Println("Updating cache")
+		slog.Info("updating cache", "file", fileName)
 		json, _ := json.Marshal(channelList2)
 		writeCache(fileName, json)
 	}
@@ -217,145 +222,22 @@ func dumpDelta(fileName string) {
 	fmt.Println(result)
 
 	if channel != "" && hasChanges {
-		postMessage(channel, result)
-	}
-}
-
-func isIgnored(element *Channel) bool {
-	var name = element.Name
-	if len(name) == 0 {
-		return true
-	}
-
-	for _, word := range ignorePrefixes {
-		if strings.HasPrefix(name, word) {
-			fmt.Printf("Ignoring: %s\n", name)
-			return true
+		if _, err = postMessage(channel, result); err != nil {
+			return err
 		}
 	}
 
-	return false
-}
-
-func getDescription(element *Channel) string {
-	description := ""
-	if element.Purpose.Value != "" {
-		description = "`" + element.Purpose.Value + "`"
-	} else if element.Topic.Value != "" {
-		description = "`" + element.Topic.Value + "`"
-	}
-	return description
-}
-
-func loadChannelsFromFile(fileName string) *ChannelList {
-
-	file, e := ioutil.ReadFile(fileName)
-	if e != nil {
-		fmt.Printf("File error: %v\n", e)
-		return nil
-	}
-
-	var channels *ChannelList
-	json.Unmarshal(file, &channels)
-
-	return channels
-}
-
-func findChannel(id string, channels *ChannelList) *Channel {
-	for _, element := range channels.Channels {
-		if element.ID == id {
-			return element
-		}
-	}
-
-	return nil
-}
-
-func loadChannelListAsJSON(cursor string) []byte {
-	url := "https://slack.com/api/conversations.list?exclude_archived=true&types=public_channel"
-
-	if len(cursor) > 0 {
-		url = url + "&cursor=" + cursor
-	}
-	fmt.Printf("UserList URL: %s\n", url)
-
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Add("Authorization", "Bearer "+apiKey)
-	response, err := client.Do(req)
-
-	if err != nil {
-		fmt.Printf("%s", err)
-		os.Exit(1)
-	} else {
-		defer response.Body.Close()
-		contents, err := ioutil.ReadAll(response.Body)
-		if err != nil {
-			fmt.Printf("%s", err)
-			os.Exit(1)
-		}
-
-		return contents
-	}
-
 	return nil
 }
 
-func loadChannelList() *ChannelList {
-	var cursor = ""
-	pageNum := 1
-	contents := loadChannelListAsJSON(cursor)
-
-	if contents == nil {
-		fmt.Printf("Current List Failed: nil conversation list returned")
-		os.Exit(1)
-	}
-	var channels *ChannelList
-	json.Unmarshal(contents, &channels)
-
-	if !channels.Ok {
-		fmt.Printf("Current List Failed: \n%#v", channels)
-		os.Exit(1)
-	}
-
-	cursor = channels.ResponseMetadata.NextCursor
-	fmt.Printf("\tNext Cursor: %s\n", cursor)
-	//cursor = ""
-	for len(cursor) > 0 {
-		pageNum = pageNum + 1
-		var data = loadChannelListAsJSON(cursor)
-		var nextPage *ChannelList
-		json.Unmarshal(data, &nextPage)
-		if nextPage == nil || !nextPage.Ok {
-			fmt.Printf("Failed to load conversation list from server: \n%#v\n", nextPage)
-			os.Exit(1)
-		}
-
-		channels.Channels = append(channels.Channels, nextPage.Channels...)
-		cursor = nextPage.ResponseMetadata.NextCursor
-		fmt.Printf("\t%d, Next Cursor: %s\n", pageNum, cursor)
-	}
-
-	return channels
-}
-
 func writeCache(filename string, byteArray []byte) {
-
-	json := string(byteArray)
-
-	fmt.Println("writing: " + filename)
-	f, err := os.Create(filename)
-	if err != nil {
-		fmt.Println(err)
-	}
-	n, err := io.WriteString(f, json)
-	if err != nil {
-		fmt.Println(n, err)
+	slog.Debug("writing", "file", filename)
+	if err := cache.Write(filename, byteArray); err != nil {
+		slog.Error("write failed", "file", filename, "error", err)
 	}
-	f.Close()
 }
 
-func postMessage(channel string, message string) []byte {
+func postMessage(channel string, message string) ([]byte, error) {
 	slackMessage := &SlackMessage{
 		channel,
 		message,
@@ -363,32 +245,20 @@ func postMessage(channel string, message string) []byte {
 	json, err := json.Marshal(slackMessage)
 	//fmt.Printf("POST: %s\n\n", json)
 
-	url := "https://slack.com/api/chat.postMessage"
+	url := conversations.APIURL + "chat.postMessage"
 	client := &http.Client{}
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(json))
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Add("Authorization", "Bearer "+apiKey)
 	req.Header.Add("Content-type", "application/json; charset=utf-8")
 	//req.Header.Add("Content-Type", "text/html; charset=utf-8")
 	response, err := client.Do(req)
-
 	if err != nil {
-		fmt.Printf("%s", err)
-		os.Exit(1)
-	} else {
-		defer response.Body.Close()
-		contents, err := ioutil.ReadAll(response.Body)
-
-		if err != nil {
-			fmt.Printf("%s", err)
-			os.Exit(1)
-		} /*
-			else {
-				fmt.Println(string(contents))
-			}
-		*/
-
-		return contents
+		return nil, err
 	}
+	defer response.Body.Close()
 
-	return nil
+	return ioutil.ReadAll(response.Body)
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/yepher/SlackRollCall/conversations"
+)
+
+/**
+Channel tracking, shared with ChannelMonitor through the conversations
+package, so channel changes can be routed together with membership changes.
+**/
+
+var ignorePrefixes []string
+
+var channelChangeFormats = map[string]string{
+	conversations.Removed:    "--- Removed Channel `%[1]s` - %[3]s",
+	conversations.Archived:   "*** Channel Changed, %[1]s, %[3]s, isDelete: YES",
+	conversations.Unarchived: "*** Channel Changed, %[1]s, %[3]s, isDelete: no",
+	conversations.Renamed:    "*** Channel Renamed, %[4]s -> <#%[2]s> - %[3]s",
+	conversations.Created:    "+++ Added Channel, <#%[2]s> - %[3]s ",
+}
+
+func dumpChannelDelta(fileName string) ([]*Event, error) {
+	var events = []*Event{}
+
+	var previousList = conversations.LoadFromFile(fileName)
+	if previousList == nil {
+		slog.Info("no channel list cached, will create one", "file", fileName)
+		previousList, err := loadChannelList()
+		if err != nil {
+			return nil, err
+		}
+		json, _ := json.Marshal(previousList)
+		writeCache(fileName, json)
+
+		return events, nil
+	}
+
+	currentList, err := loadChannelList()
+	if err != nil {
+		return nil, err
+	}
+
+	events = diffChannels(previousList, currentList)
+
+	if saveCache {
+		slog.Info("updating channel cache", "file", fileName)
+		json, _ := json.Marshal(currentList)
+		writeCache(fileName, json)
+	} else if dryRun {
+		showDryRun("channel cache "+fileName+" would not be updated, -u is not true", nil)
+	}
+
+	return events, nil
+}
+
+// diffChannels returns the events for the changes between two channel lists
+func diffChannels(previousList *conversations.List, currentList *conversations.List) []*Event {
+	var events = []*Event{}
+
+	for _, change := range conversations.Diff(previousList, currentList, ignorePrefixes) {
+		previousName := ""
+		if change.Previous != nil {
+			previousName = change.Previous.Name
+		}
+
+		text := fmt.Sprintf(channelChangeFormats[change.Type],
+			change.Channel.Name,
+			change.Channel.ID,
+			conversations.Description(change.Channel),
+			previousName)
+		events = append(events, newChannelEvent(change.Type, change.Channel, text))
+	}
+
+	return events
+}
+
+func loadChannelList() (*conversations.List, error) {
+	channels, err := conversations.Load(apiKey)
+	if err != nil {
+		recordFetchFailure("channels")
+	}
+	return channels, err
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"gopkg.in/yaml.v2"
+)
+
+/**
+Configuration file.
+
+	--config (or $ROLLCALL_CONFIG) names a YAML file whose keys are the long
+	names of the global options. Values are plain YAML: true and false,
+	numbers, and lists instead of comma separated text. An option given on
+	the command line or through its environment variable overrides the file.
+
+		apikey: xoxb-...
+		cache: /var/lib/rollcall/userList.cache
+		channelcache: /var/lib/rollcall/channelList.cache
+		updatecache: true
+		monitor: [gmail.com, "*.mail.ru", "title:ceo"]
+		ignore: [test-, tmp-]
+		channel: security
+		webhook: [https://example.com/hook]
+		routes: /etc/rollcall/routes.json
+		watch: "0 * * * *"
+
+	"SlackRollCall config validate --config rollcall.yml" checks the file
+	the same way a run does, without contacting Slack or starting anything.
+**/
+
+var configFlag = cli.StringFlag{
+	Name:   "config, f",
+	Value:  "",
+	Usage:  "Optional, YAML file with the global options",
+	EnvVar: "ROLLCALL_CONFIG",
+}
+
+var configCommand = cli.Command{
+	Name:  "config",
+	Usage: "Work with the configuration file",
+	Subcommands: []cli.Command{
+		{
+			Name:  "validate",
+			Usage: "Check a configuration file",
+			Flags: []cli.Flag{configFlag},
+			Action: func(c *cli.Context) {
+				fileName := c.String("config")
+				if fileName == "" {
+					fmt.Printf("\n\nError: --config must be set\n\n")
+					os.Exit(1)
+				}
+
+				if err := validateConfig(c, fileName); err != nil {
+					fmt.Printf("%s: %s\n", fileName, strings.TrimPrefix(err.Error(), fileName+": "))
+					os.Exit(1)
+				}
+				fmt.Printf("%s: ok\n", fileName)
+			},
+		},
+	},
+}
+
+// validateConfig sets up everything the config file asks for the same way
+// a run does, without contacting Slack or starting anything
+func validateConfig(c *cli.Context, fileName string) error {
+	// The global options belong to the app the command was started from
+	root := c
+	for root.Parent() != nil {
+		root = root.Parent()
+	}
+
+	if err := root.Set("config", fileName); err != nil {
+		return err
+	}
+	return configure(root)
+}
+
+// applyConfig sets every option from the --config file that was not given
+// on the command line or through its environment variable
+func applyConfig(c *cli.Context) error {
+	fileName := c.String("config")
+	if fileName == "" {
+		return nil
+	}
+
+	settings, err := loadConfig(fileName, c.App.Flags)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range settings {
+		if c.IsSet(name) {
+			continue
+		}
+		if err = c.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %s: %v", fileName, name, err)
+		}
+	}
+
+	return nil
+}
+
+// loadConfig reads a config file into option values as they would be
+// written on the command line
+func loadConfig(fileName string, flags []cli.Flag) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var document map[string]interface{}
+	if err = yaml.Unmarshal(contents, &document); err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+
+	known := configOptions(flags)
+	settings := map[string]string{}
+	for name, raw := range document {
+		option, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown option %q", fileName, name)
+		}
+
+		value, err := configValue(raw)
+		if err == nil {
+			err = checkConfigType(option, value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", fileName, name, err)
+		}
+		settings[name] = value
+	}
+
+	return settings, nil
+}
+
+// configOptions maps the long name of every global option but --config to its flag
+func configOptions(flags []cli.Flag) map[string]cli.StringFlag {
+	options := map[string]cli.StringFlag{}
+	for _, flag := range flags {
+		option, ok := flag.(cli.StringFlag)
+		if !ok {
+			continue
+		}
+		name := strings.TrimSpace(strings.Split(option.Name, ",")[0])
+		if name != "config" {
+			options[name] = option
+		}
+	}
+	return options
+}
+
+// configValue converts a YAML value to the text of a string flag
+func configValue(raw interface{}) (string, error) {
+	switch value := raw.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case int:
+		return strconv.Itoa(value), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case []interface{}:
+		entries := []string{}
+		for _, entry := range value {
+			text, err := configValue(entry)
+			if err != nil {
+				return "", err
+			}
+			if _, nested := entry.([]interface{}); nested || strings.Contains(text, ",") {
+				return "", fmt.Errorf("list entries must be single values without commas")
+			}
+			entries = append(entries, text)
+		}
+		return strings.Join(entries, ","), nil
+	default:
+		return "", fmt.Errorf("must be a value or a list of values")
+	}
+}
+
+// checkConfigType holds a value to the type of the option's default: true
+// or false, a number, or free text
+func checkConfigType(option cli.StringFlag, value string) error {
+	if value == "" {
+		return nil
+	}
+
+	switch {
+	case option.Value == "true" || option.Value == "false":
+		if value != "true" && value != "false" {
+			return fmt.Errorf("must be true or false")
+		}
+	case isNumber(option.Value):
+		if !isNumber(value) {
+			return fmt.Errorf("must be a number")
+		}
+	}
+	return nil
+}
+
+func isNumber(value string) bool {
+	_, err := strconv.ParseFloat(value, 64)
+	return err == nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codegangsta/cli"
+)
+
+func writeTestConfig(t *testing.T, contents string) string {
+	fileName := filepath.Join(t.TempDir(), "rollcall.yml")
+	if err := ioutil.WriteFile(fileName, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestApplyConfig(t *testing.T) {
+	fileName := writeTestConfig(t, "apikey: from-file\ncache: /file/cache\nupdatecache: true\nmonitor: [gmail.com, \"*.mail.ru\"]\nriskthreshold: 70\nchannel: filechan\nwatch: \"0 * * * *\"\n")
+	t.Setenv("TEST_ROLLCALL_KEY", "from-env")
+
+	got := map[string]string{}
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{
+		configFlag,
+		cli.StringFlag{Name: "apikey, k", EnvVar: "TEST_ROLLCALL_KEY"},
+		cli.StringFlag{Name: "cache, c", Value: "./userList.cache"},
+		cli.StringFlag{Name: "updatecache, u", Value: "false"},
+		cli.StringFlag{Name: "monitor, m"},
+		cli.StringFlag{Name: "riskthreshold", Value: "60"},
+		cli.StringFlag{Name: "channel, l"},
+		cli.StringFlag{Name: "watch, w"},
+	}
+	app.Action = func(c *cli.Context) {
+		if err := applyConfig(c); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"apikey", "cache", "updatecache", "monitor", "riskthreshold", "channel", "watch"} {
+			got[name] = c.String(name)
+		}
+	}
+	app.Run([]string{"SlackRollCall", "--config", fileName, "-c", "/flag/cache", "--channel", "flagchan"})
+
+	want := map[string]string{
+		"apikey":        "from-env",
+		"cache":         "/flag/cache",
+		"updatecache":   "true",
+		"monitor":       "gmail.com,*.mail.ru",
+		"riskthreshold": "70",
+		"channel":       "flagchan",
+		"watch":         "0 * * * *",
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s = %q, want %q", name, got[name], value)
+		}
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	// An empty variable still counts as set and would override the file
+	t.Setenv("SLACK_API_KEY", "")
+	os.Unsetenv("SLACK_API_KEY")
+	t.Cleanup(func() { notifiers = []Notifier{} })
+
+	tests := []struct {
+		name     string
+		contents string
+		problem  string
+	}{
+		{"valid", "apikey: k\ncache: /tmp/userList.cache\nwatch: \"0 * * * *\"\nraidthreshold: 5\n", ""},
+		{"no api key", "cache: /tmp/userList.cache\n", "API key"},
+		{"unknown option", "apikey: k\nnosuch: 1\n", "unknown option"},
+		{"not a boolean", "apikey: k\nupdatecache: yes please\n", "true or false"},
+		{"not a number", "apikey: k\nriskthreshold: high\n", "must be a number"},
+		{"risk threshold out of range", "apikey: k\nriskthreshold: 150\n", "--riskthreshold"},
+		{"no raid threshold", "apikey: k\nraidthreshold: 0\n", "--raidthreshold"},
+		{"bad escalation target", "apikey: k\nescalate: [bogus]\n", "--escalate"},
+		{"email without addresses", "apikey: k\nsmtphost: smtp.example.com\n", "--emailfrom and --emailto"},
+		{"events without a signing secret", "apikey: k\nlisten: 127.0.0.1:3000\n", "--signingsecret"},
+		{"dashboard on a loopback address", "apikey: k\nserve: 127.0.0.1:8081\n", ""},
+		{"open dashboard", "apikey: k\nserve: \":8081\"\n", "--apitoken"},
+		{"protected dashboard", "apikey: k\nserve: \":8081\"\napitoken: t\n", ""},
+		{"bad schedule", "apikey: k\nwatch: \"61 * * * *\"\n", "--watch"},
+		{"bad deadman", "apikey: k\ndeadman: soon\n", "--deadman"},
+		{"missing rules", "apikey: k\nrules: /nonexistent/rules.json\n", "rules.json"},
+		{"dry run with a schedule", "apikey: k\ndry-run: true\nwatch: 1h\n", "--dry-run"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName := writeTestConfig(t, test.contents)
+
+			var err error
+			app := newApp()
+			app.Action = func(c *cli.Context) {
+				err = validateConfig(c, fileName)
+			}
+			app.Run([]string{"SlackRollCall"})
+
+			switch {
+			case test.problem == "" && err != nil:
+				t.Fatalf("got %v, want no problem", err)
+			case test.problem != "" && err == nil:
+				t.Fatalf("got no problem, want %q", test.problem)
+			case test.problem != "" && !strings.Contains(err.Error(), test.problem):
+				t.Fatalf("got %v, want %q", err, test.problem)
+			}
+		})
+	}
+}
+
+func TestConfigValidateCommand(t *testing.T) {
+	fileName := writeTestConfig(t, "apikey: k\nchannel: security\n")
+	t.Setenv("SLACK_API_KEY", "")
+	os.Unsetenv("SLACK_API_KEY")
+	t.Cleanup(func() { notifiers = []Notifier{} })
+
+	stdout := os.Stdout
+	read, write, _ := os.Pipe()
+	os.Stdout = write
+	newApp().Run([]string{"SlackRollCall", "config", "validate", "--config", fileName})
+	os.Stdout = stdout
+	write.Close()
+
+	out, _ := ioutil.ReadAll(read)
+	if string(out) != fileName+": ok\n" {
+		t.Fatalf("got %q", out)
+	}
+	if len(notifiers) != 1 || notifiers[0].Name() != "Slack security" {
+		t.Fatal("the options in the file were not applied")
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yepher/SlackRollCall/conversations"
+)
+
+/**
+Web dashboard.
+
+	With --serve SlackRollCall keeps running and serves a small dashboard:
+	the roster from the member cache, a filterable timeline of the changes
+	recorded in --eventlog, the channels from the channel cache and a page per
+	member with their record and history. The pages and stylesheet are
+	embedded in the binary.
+
+	When --apitoken is set the pages ask for HTTP basic authentication with
+	one of the API tokens as the password, as they show the same data as the
+	API. The stylesheet, /metrics, /healthz and /readyz stay open. Without a
+	token the dashboard is only served on a loopback address.
+**/
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// dashboardTimelineLimit is the most events shown on the timeline
+const dashboardTimelineLimit = 500
+
+var dashboardFuncs = template.FuncMap{
+	"name":  displayName,
+	"role":  memberRole,
+	"title": eventTitle,
+	"time": func(t time.Time) string {
+		return t.Local().Format("2006-01-02 15:04")
+	},
+}
+
+// DashboardPage is the data every dashboard template is rendered with
+type DashboardPage struct {
+	Title    string
+	Query    string
+	Type     string
+	Types    []string
+	Members  []*User
+	Channels []*conversations.Channel
+	Events   []*Event
+	User     *User
+}
+
+type dashboard struct {
+	pages map[string]*template.Template
+}
+
+// newDashboard parses the embedded page templates and routes the pages on the mux
+func newDashboard(mux *http.ServeMux) error {
+	board := &dashboard{pages: map[string]*template.Template{}}
+	for _, page := range []string{"roster", "timeline", "channels", "user"} {
+		parsed, err := template.New(page).Funcs(dashboardFuncs).ParseFS(dashboardFiles, "dashboard/layout.html", "dashboard/"+page+".html")
+		if err != nil {
+			return err
+		}
+		board.pages[page] = parsed
+	}
+
+	static, err := fs.Sub(dashboardFiles, "dashboard/static")
+	if err != nil {
+		return err
+	}
+
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(static))))
+	mux.Handle("/", dashboardAuth(board.roster))
+	mux.Handle("/timeline", dashboardAuth(board.timeline))
+	mux.Handle("/channels", dashboardAuth(board.channels))
+	mux.Handle("/users/", dashboardAuth(board.user))
+	return nil
+}
+
+// dashboardAuth only lets requests with one of the API tokens through when
+// any are set, as the password of basic authentication or a bearer token
+// isLoopbackAddress reports whether the listen address only accepts
+// connections from the same host
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func dashboardAuth(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(apiTokens) > 0 {
+			token, ok := "", false
+			if _, token, ok = r.BasicAuth(); !ok {
+				token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			}
+
+			if !validAPIToken(token) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="SlackRollCall"`)
+				http.Error(w, "invalid or missing token", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		handler(w, r)
+	})
+}
+
+func (board *dashboard) render(w http.ResponseWriter, page string, data *DashboardPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := board.pages[page].ExecuteTemplate(w, "layout", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (board *dashboard) roster(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := &DashboardPage{Title: "Roster", Query: r.URL.Query().Get("q"), Members: []*User{}}
+	if members := loadMembersFromFile(memberCacheFile); members != nil {
+		for _, member := range members.Members {
+			if data.Query == "" || memberMatches(member, data.Query) {
+				data.Members = append(data.Members, member)
+			}
+		}
+	}
+	sort.Slice(data.Members, func(i, j int) bool {
+		return strings.ToLower(displayName(data.Members[i])) < strings.ToLower(displayName(data.Members[j]))
+	})
+
+	board.render(w, "roster", data)
+}
+
+func (board *dashboard) timeline(w http.ResponseWriter, r *http.Request) {
+	events, err := loadEventLog()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := &DashboardPage{
+		Title:  "Timeline",
+		Query:  r.URL.Query().Get("q"),
+		Type:   r.URL.Query().Get("type"),
+		Types:  eventTypes(events),
+		Events: []*Event{},
+	}
+
+	for i := len(events) - 1; i >= 0 && len(data.Events) < dashboardTimelineLimit; i-- {
+		event := events[i]
+		if data.Type != "" && event.Type != data.Type {
+			continue
+		}
+		if data.Query != "" && !caseInsensitiveContains(event.Text, data.Query) {
+			continue
+		}
+		data.Events = append(data.Events, event)
+	}
+
+	board.render(w, "timeline", data)
+}
+
+func (board *dashboard) channels(w http.ResponseWriter, r *http.Request) {
+	data := &DashboardPage{Title: "Channels", Query: r.URL.Query().Get("q"), Channels: []*conversations.Channel{}}
+	if channels := conversations.LoadFromFile(channelCacheFile); channels != nil {
+		for _, channel := range channels.Channels {
+			if data.Query == "" || caseInsensitiveContains(channel.Name+" "+conversations.Description(channel), data.Query) {
+				data.Channels = append(data.Channels, channel)
+			}
+		}
+	}
+	sort.Slice(data.Channels, func(i, j int) bool { return data.Channels[i].Name < data.Channels[j].Name })
+
+	board.render(w, "channels", data)
+}
+
+func (board *dashboard) user(w http.ResponseWriter, r *http.Request) {
+	id := path.Base(r.URL.Path)
+
+	events, err := loadEventLog()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := &DashboardPage{Events: []*Event{}}
+	if members := loadMembersFromFile(memberCacheFile); members != nil {
+		data.User = findMember(id, members)
+	}
+
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].involves(id) {
+			data.Events = append(data.Events, events[i])
+			// Members no longer in the cache are shown as last recorded
+			if data.User == nil && events[i].User != nil && events[i].User.ID == id {
+				data.User = events[i].User
+			}
+		}
+	}
+
+	if data.User == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	data.Title = displayName(data.User)
+	board.render(w, "user", data)
+}
+
+// memberMatches reports whether the query is part of the member's names, email or title
+func memberMatches(member *User, query string) bool {
+	return caseInsensitiveContains(strings.Join([]string{member.ID, member.Name, member.RealName,
+		member.Profile.RealName, member.Profile.Email, member.Profile.Title}, " "), query)
+}
+
+// eventTypes lists the event types found in the events, sorted
+func eventTypes(events []*Event) []string {
+	found := map[string]bool{}
+	for _, event := range events {
+		found[event.Type] = true
+	}
+
+	types := []string{}
+	for eventType := range found {
+		types = append(types, eventType)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDashboardAuth(t *testing.T) {
+	dir := t.TempDir()
+	memberCacheFile = filepath.Join(dir, "userList.cache")
+	eventLogFile = filepath.Join(dir, "events.jsonl")
+	writeTestCache(t, memberCacheFile, &MemberList{Ok: true, Members: []*User{testUser("U1", "alice", "alice@example.com")}})
+
+	apiTokens = []string{"secret"}
+	t.Cleanup(func() {
+		memberCacheFile, eventLogFile = "", ""
+		apiTokens = []string{}
+	})
+
+	mux := http.NewServeMux()
+	if err := newDashboard(mux); err != nil {
+		t.Fatal(err)
+	}
+	newAPI(mux)
+	mux.HandleFunc("/healthz", serveHealthz)
+
+	tests := []struct {
+		name   string
+		path   string
+		auth   func(r *http.Request)
+		status int
+	}{
+		{"roster without token", "/", nil, http.StatusUnauthorized},
+		{"timeline without token", "/timeline", nil, http.StatusUnauthorized},
+		{"channels without token", "/channels", nil, http.StatusUnauthorized},
+		{"user without token", "/users/U1", nil, http.StatusUnauthorized},
+		{"wrong password", "/", func(r *http.Request) { r.SetBasicAuth("admin", "guess") }, http.StatusUnauthorized},
+		{"basic auth", "/", func(r *http.Request) { r.SetBasicAuth("admin", "secret") }, http.StatusOK},
+		{"bearer token", "/users/U1", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, http.StatusOK},
+		{"api without token", "/api/v1/users", nil, http.StatusUnauthorized},
+		{"api with token", "/api/v1/users", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, http.StatusOK},
+		{"stylesheet", "/static/style.css", nil, http.StatusOK},
+		{"health", "/healthz", nil, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.auth != nil {
+				test.auth(request)
+			}
+			response := httptest.NewRecorder()
+			mux.ServeHTTP(response, request)
+
+			if response.Code != test.status {
+				t.Fatalf("got status %d, want %d", response.Code, test.status)
+			}
+			if test.status == http.StatusUnauthorized && !strings.HasPrefix(test.path, "/api/") && response.Header().Get("WWW-Authenticate") == "" {
+				t.Fatal("the browser is not asked to log in")
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import "unicode/utf8"
+
+/**
+Discord Webhook: https://discord.com/developers/docs/resources/webhook#execute-webhook
+	Example: https://discord.com/api/webhooks/000000000000000000/XXXXXXXX
+
+	Each event becomes a colored embed. Discord accepts at most ten embeds
+	and 6000 characters of embed text per message so larger reports are
+	sent as several messages.
+**/
+
+const discordMaxEmbeds = 10
+const discordMaxCharacters = 6000
+
+// DiscordNotifier posts the report to a Discord webhook
+type DiscordNotifier struct {
+	URL string
+}
+
+// DiscordMessage is the body accepted by a Discord webhook
+type DiscordMessage struct {
+	Username string          `json:"username"`
+	Content  string          `json:"content,omitempty"`
+	Embeds   []*DiscordEmbed `json:"embeds"`
+}
+
+// DiscordEmbed describes a single event in a DiscordMessage
+type DiscordEmbed struct {
+	Title  string               `json:"title"`
+	Color  int                  `json:"color"`
+	Fields []*DiscordEmbedField `json:"fields"`
+}
+
+// DiscordEmbedField is a name/value row in a DiscordEmbed
+type DiscordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// Name identifies the notifier in error messages
+func (notifier *DiscordNotifier) Name() string {
+	return "Discord"
+}
+
+// Notify posts the events as embeds, ten or 6000 characters at a time
+func (notifier *DiscordNotifier) Notify(report *Report) error {
+	messages := [][]*DiscordEmbed{}
+	embeds, characters := []*DiscordEmbed{}, 0
+	for _, event := range report.Events {
+		embed := &DiscordEmbed{
+			Title: eventTitle(event),
+			Color: eventColor(event),
+		}
+		for _, field := range eventFields(event) {
+			embed.Fields = append(embed.Fields, &DiscordEmbedField{field.Name, field.Value, true})
+		}
+
+		size := embed.characters()
+		if len(embeds) == discordMaxEmbeds || (len(embeds) > 0 && characters+size > discordMaxCharacters) {
+			messages = append(messages, embeds)
+			embeds, characters = []*DiscordEmbed{}, 0
+		}
+		embeds = append(embeds, embed)
+		characters += size
+	}
+	if len(embeds) > 0 {
+		messages = append(messages, embeds)
+	}
+
+	content := "**" + report.Title + "**"
+	for _, embeds := range messages {
+		_, err := postJSON(notifier.URL, &DiscordMessage{
+			Username: "Slack Roll Call",
+			Content:  content,
+			Embeds:   embeds,
+		})
+		if err != nil {
+			return err
+		}
+		content = ""
+	}
+
+	return nil
+}
+
+// characters counts the text Discord holds against the per message limit
+func (embed *DiscordEmbed) characters() int {
+	count := utf8.RuneCountInString(embed.Title)
+	for _, field := range embed.Fields {
+		count += utf8.RuneCountInString(field.Name) + utf8.RuneCountInString(field.Value)
+	}
+	return count
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiscordNotifier(t *testing.T) {
+	tests := []struct {
+		events int
+		embeds []int
+	}{
+		{1, []int{1}},
+		{10, []int{10}},
+		{11, []int{10, 1}},
+		{23, []int{10, 10, 3}},
+	}
+
+	for _, test := range tests {
+		url, payloads := recordPayloads(t)
+
+		events := []*Event{}
+		for i := 0; i < test.events; i++ {
+			events = append(events, newMemberEvent(EventMemberJoined, testUser(fmt.Sprintf("U%d", i), fmt.Sprintf("member %d", i), ""), nil, ""))
+		}
+
+		notifier := &DiscordNotifier{url}
+		if err := notifier.Notify(&Report{Title: "Roll Call", Events: events}); err != nil {
+			t.Fatal(err)
+		}
+		if len(*payloads) != len(test.embeds) {
+			t.Fatalf("%d events: %d messages posted, want %d", test.events, len(*payloads), len(test.embeds))
+		}
+
+		for i, payload := range *payloads {
+			message := &DiscordMessage{}
+			if err := json.Unmarshal(payload, message); err != nil {
+				t.Fatal(err)
+			}
+
+			// The title heads the first message only
+			content := ""
+			if i == 0 {
+				content = "**Roll Call**"
+			}
+			if len(message.Embeds) != test.embeds[i] || message.Content != content || message.Username != "Slack Roll Call" {
+				t.Errorf("%d events, message %d: got %d embeds and %q, want %d and %q",
+					test.events, i, len(message.Embeds), message.Content, test.embeds[i], content)
+			}
+			if embed := message.Embeds[0]; embed.Title != "New Member" || embed.Color != 0x2EB67D || embed.Fields[0].Value == "" {
+				t.Errorf("%d events, message %d: got embed %q %06X", test.events, i, embed.Title, embed.Color)
+			}
+		}
+	}
+}
+
+func TestDiscordNotifierCharacterLimit(t *testing.T) {
+	url, payloads := recordPayloads(t)
+
+	events := []*Event{}
+	for i := 0; i < 5; i++ {
+		user := testUser(fmt.Sprintf("U%d", i), fmt.Sprintf("member %d", i), "")
+		user.Profile.Title = strings.Repeat("é", 1900)
+		events = append(events, newMemberEvent(EventMemberJoined, user, nil, ""))
+	}
+
+	notifier := &DiscordNotifier{url}
+	if err := notifier.Notify(&Report{Title: "Roll Call", Events: events}); err != nil {
+		t.Fatal(err)
+	}
+
+	embeds := 0
+	for i, payload := range *payloads {
+		message := &DiscordMessage{}
+		if err := json.Unmarshal(payload, message); err != nil {
+			t.Fatal(err)
+		}
+		characters := 0
+		for _, embed := range message.Embeds {
+			characters += embed.characters()
+		}
+		if characters > discordMaxCharacters {
+			t.Errorf("message %d: %d characters of embeds", i, characters)
+		}
+		embeds += len(message.Embeds)
+	}
+	if len(*payloads) != 2 || embeds != 5 {
+		t.Errorf("got %d embeds in %d messages, want 5 in 2", embeds, len(*payloads))
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	_ "embed" // domain lists are compiled into the binary
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+/**
+Disposable and free email provider detection.
+
+	Both lists ship inside the binary (data/*.txt) and can be extended with
+	--disposablelist and --freemaillist, which also turn the detection on.
+	The updatedomains command downloads a community maintained disposable
+	domain list to use with --disposablelist.
+**/
+
+const defaultDisposableListURL = "https://raw.githubusercontent.com/disposable-email-domains/disposable-email-domains/master/disposable_email_blocklist.conf"
+
+//go:embed data/disposable_domains.txt
+var embeddedDisposableDomains string
+
+//go:embed data/freemail_domains.txt
+var embeddedFreemailDomains string
+
+var disposableDomains map[string]bool
+var freemailDomains map[string]bool
+
+// updateDomainsCommand refreshes a local disposable domain list
+var updateDomainsCommand = cli.Command{
+	Name:  "updatedomains",
+	Usage: "Download the latest disposable email domain list for use with --disposablelist",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "url",
+			Value: defaultDisposableListURL,
+			Usage: "Optional, list to download, one domain per line",
+		},
+		cli.StringFlag{
+			Name:  "out, o",
+			Value: "./disposable_domains.txt",
+			Usage: "Optional, file to save the list to",
+		},
+	},
+	Action: func(c *cli.Context) {
+		count, err := downloadDomainList(c.String("url"), c.String("out"))
+		if err != nil {
+			fmt.Printf("\n\nError: %v\n\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Saved %d domains to %s\n", count, c.String("out"))
+	},
+}
+
+// loadDomainLists enables disposable and/or free email detection, merging
+// the embedded lists with any extra list files
+func loadDomainLists(disposable bool, disposableFiles []string, freemail bool, freemailFiles []string) error {
+	disposableDomains, freemailDomains = nil, nil
+
+	if disposable {
+		disposableDomains = map[string]bool{}
+		addDomains(disposableDomains, embeddedDisposableDomains)
+		for _, fileName := range disposableFiles {
+			if err := addDomainFile(disposableDomains, fileName); err != nil {
+				return err
+			}
+		}
+	}
+
+	if freemail {
+		freemailDomains = map[string]bool{}
+		addDomains(freemailDomains, embeddedFreemailDomains)
+		for _, fileName := range freemailFiles {
+			if err := addDomainFile(freemailDomains, fileName); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func addDomainFile(domains map[string]bool, fileName string) error {
+	file, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	addDomains(domains, string(file))
+	return nil
+}
+
+// addDomains adds every domain in a one domain per line list, skipping
+// blank lines and # comments
+func addDomains(domains map[string]bool, list string) int {
+	count := 0
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains[strings.TrimPrefix(line, "@")] = true
+		count++
+	}
+	return count
+}
+
+// isListedDomain reports whether domain, or any domain it is a subdomain
+// of, is in the list
+func isListedDomain(domains map[string]bool, domain string) bool {
+	for domain != "" {
+		if domains[domain] {
+			return true
+		}
+
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			break
+		}
+		domain = domain[dot+1:]
+	}
+	return false
+}
+
+func isDisposable(user *User) bool {
+	return disposableDomains != nil && isListedDomain(disposableDomains, emailDomain(user.Profile.Email))
+}
+
+func isFreemail(user *User) bool {
+	return freemailDomains != nil && isListedDomain(freemailDomains, emailDomain(user.Profile.Email))
+}
+
+func downloadDomainList(url string, fileName string) (int, error) {
+	client := &http.Client{Timeout: 60 * time.Second}
+	response, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s: %s", url, response.Status)
+	}
+
+	contents, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	count := addDomains(map[string]bool{}, string(contents))
+	if count == 0 {
+		return 0, fmt.Errorf("%s: no domains found", url)
+	}
+
+	return count, ioutil.WriteFile(fileName, contents, 0644)
+}
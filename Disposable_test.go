@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codegangsta/cli"
+)
+
+func TestDisposableAndFreemail(t *testing.T) {
+	extra := filepath.Join(t.TempDir(), "extra.txt")
+	if err := ioutil.WriteFile(extra, []byte("# our own\n\n@Burner.example\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadDomainLists(true, []string{extra}, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { disposableDomains, freemailDomains = nil, nil })
+
+	tests := []struct {
+		email      string
+		disposable bool
+		freemail   bool
+	}{
+		{"ann@mailinator.com", true, false},
+		{"ann@MAILINATOR.COM", true, false},
+		{"ann@eu.mailinator.com", true, false},
+		{"ann@notmailinator.com", false, false},
+		{"ann@burner.example", true, false},
+		{"ann@gmail.com", false, true},
+		{"ann@gmail.com.", false, true},
+		{"ann@ourcompany.com", false, false},
+		{"", false, false},
+	}
+
+	for _, test := range tests {
+		user := testUser("U1", "ann", test.email)
+		if disposable := isDisposable(user); disposable != test.disposable {
+			t.Errorf("%q: disposable %v, want %v", test.email, disposable, test.disposable)
+		}
+		if freemail := isFreemail(user); freemail != test.freemail {
+			t.Errorf("%q: free email %v, want %v", test.email, freemail, test.freemail)
+		}
+	}
+
+	if err := loadDomainLists(true, []string{filepath.Join(t.TempDir(), "missing.txt")}, false, nil); err == nil {
+		t.Fatal("a missing list was accepted")
+	}
+}
+
+func TestDomainListTurnsOnDetection(t *testing.T) {
+	// An empty variable still counts as set
+	t.Setenv("SLACK_API_KEY", "")
+	os.Unsetenv("SLACK_API_KEY")
+
+	extra := filepath.Join(t.TempDir(), "extra.txt")
+	if err := ioutil.WriteFile(extra, []byte("burner.example\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		disposableDomains, freemailDomains = nil, nil
+		notifiers = []Notifier{}
+	})
+
+	tests := []struct {
+		args       []string
+		disposable bool
+		freemail   bool
+	}{
+		{[]string{}, false, false},
+		{[]string{"--disposable", "true"}, true, false},
+		{[]string{"--disposablelist", extra}, true, false},
+		{[]string{"--freemaillist", extra}, false, true},
+	}
+
+	for _, test := range tests {
+		var err error
+		app := newApp()
+		app.Action = func(c *cli.Context) {
+			err = configure(c)
+		}
+		app.Run(append([]string{"SlackRollCall", "-k", "k"}, test.args...))
+		if err != nil {
+			t.Fatalf("%v: %v", test.args, err)
+		}
+
+		user := testUser("U1", "ann", "ann@burner.example")
+		if test.disposable {
+			user.Profile.Email = "ann@mailinator.com"
+		}
+		if disposable := isDisposable(user); disposable != test.disposable {
+			t.Errorf("%v: disposable %v, want %v", test.args, disposable, test.disposable)
+		}
+		if freemail := isFreemail(testUser("U1", "ann", "ann@burner.example")); freemail != test.freemail {
+			t.Errorf("%v: free email %v, want %v", test.args, freemail, test.freemail)
+		}
+	}
+}
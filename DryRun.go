@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+/**
+Dry run.
+
+	--dry-run fetches and diffs as usual, then prints what every notifier
+	would send and which files would be written instead of posting or
+	writing anything. Webhook URLs carry credentials, so only their host is
+	shown.
+**/
+
+var dryRun = false
+
+// showDryRun prints an action that was skipped and the payload it would
+// have sent
+func showDryRun(action string, payload []byte) {
+	fmt.Printf("\n--- Dry run: %s\n", action)
+	if len(payload) == 0 {
+		return
+	}
+
+	var indented bytes.Buffer
+	if json.Indent(&indented, payload, "", "  ") == nil {
+		payload = indented.Bytes()
+	}
+	fmt.Printf("%s\n", payload)
+}
+
+// dryRunHost is the part of a destination URL that is safe to print
+func dryRunHost(destination string) string {
+	parsed, err := url.Parse(destination)
+	if err != nil || parsed.Host == "" {
+		return "an invalid URL"
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"html"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+/**
+Email delivery of the change report.
+
+	The report is sent as a multipart/alternative message with a plain text
+	and an HTML part. STARTTLS is used whenever the server offers it, which
+	also lets the report be delivered to a local SMTP sink for testing.
+**/
+
+// EmailNotifier emails the change report through an SMTP server
+type EmailNotifier struct {
+	Host     string
+	User     string
+	Password string
+	StartTLS string
+	From     string
+	To       []string
+}
+
+// Name identifies the notifier in error messages
+func (notifier *EmailNotifier) Name() string {
+	return "Email"
+}
+
+// Notify emails the report text to every recipient
+func (notifier *EmailNotifier) Notify(report *Report) error {
+	return sendEmail(notifier, report.Title, report.Text)
+}
+
+func sendEmail(settings *EmailNotifier, subject string, report string) error {
+	message, err := buildEmailMessage(settings, subject, report)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		showDryRun(fmt.Sprintf("would email %s through %s", strings.Join(settings.To, ", "), settings.Host), message)
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(settings.Host)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP host %q: %v", settings.Host, err)
+	}
+
+	client, err := smtp.Dial(settings.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if settings.StartTLS != "false" {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err = client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+				return err
+			}
+		} else if settings.StartTLS == "true" {
+			return fmt.Errorf("%s does not support STARTTLS", settings.Host)
+		}
+	}
+
+	if settings.User != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("%s does not support authentication", settings.Host)
+		}
+		if err = client.Auth(smtp.PlainAuth("", settings.User, settings.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err = client.Mail(settings.From); err != nil {
+		return err
+	}
+	for _, recipient := range settings.To {
+		if err = client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = writer.Write(message); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func buildEmailMessage(settings *EmailNotifier, subject string, report string) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+
+	htmlReport := "<html><body><pre style=\"font-family: monospace\">" +
+		html.EscapeString(report) +
+		"</pre></body></html>"
+
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", report},
+		{"text/html; charset=utf-8", htmlReport},
+	} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+		w, err := parts.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+
+		qp := quotedprintable.NewWriter(w)
+		if _, err = qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err = qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", settings.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(settings.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	message.Write(body.Bytes())
+
+	return message.Bytes(), nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// smtpMessage is what the SMTP sink received in one session
+type smtpMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+// newSMTPSink accepts mail on a local port without STARTTLS or AUTH and
+// hands every delivered message to the channel
+func newSMTPSink(t *testing.T) (string, chan *smtpMessage) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	messages := make(chan *smtpMessage, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(textproto.NewConn(conn), messages)
+		}
+	}()
+
+	return listener.Addr().String(), messages
+}
+
+func serveSMTP(conn *textproto.Conn, messages chan *smtpMessage) {
+	defer conn.Close()
+
+	message := &smtpMessage{}
+	conn.PrintfLine("220 sink ESMTP")
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			conn.PrintfLine("250 sink")
+		case "MAIL":
+			message.From = line[len("MAIL FROM:"):]
+			conn.PrintfLine("250 OK")
+		case "RCPT":
+			message.To = append(message.To, line[len("RCPT TO:"):])
+			conn.PrintfLine("250 OK")
+		case "DATA":
+			conn.PrintfLine("354 Go ahead")
+			data, err := conn.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			message.Data = string(data)
+			messages <- message
+			message = &smtpMessage{}
+			conn.PrintfLine("250 Queued")
+		case "QUIT":
+			conn.PrintfLine("221 Bye")
+			return
+		default:
+			conn.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+func TestSendEmail(t *testing.T) {
+	host, messages := newSMTPSink(t)
+
+	notifier := &EmailNotifier{
+		Host: host,
+		From: "rollcall@example.com",
+		To:   []string{"security@example.com", "it@example.com"},
+	}
+	if err := notifier.Notify(&Report{Title: "Slack Roll Call: membership changes", Text: "+++ New Member, Ann <ann@example.com>"}); err != nil {
+		t.Fatal(err)
+	}
+
+	message := <-messages
+	if message.From != "<rollcall@example.com>" || strings.Join(message.To, ",") != "<security@example.com>,<it@example.com>" {
+		t.Fatalf("got envelope %s -> %v", message.From, message.To)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(message.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject")); subject != "Slack Roll Call: membership changes" {
+		t.Fatalf("got subject %q", subject)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("got content type %q", parsed.Header.Get("Content-Type"))
+	}
+
+	want := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", "+++ New Member, Ann <ann@example.com>"},
+		{"text/html; charset=utf-8", "+++ New Member, Ann &lt;ann@example.com&gt;"},
+	}
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	for _, expected := range want {
+		part, err := parts.NextRawPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(quotedprintable.NewReader(part))
+		if part.Header.Get("Content-Type") != expected.contentType || !strings.Contains(string(content), expected.content) {
+			t.Fatalf("got %s part %q", part.Header.Get("Content-Type"), content)
+		}
+	}
+}
+
+func TestSendEmailRefusals(t *testing.T) {
+	host, _ := newSMTPSink(t)
+
+	tests := []struct {
+		name     string
+		startTLS string
+		user     string
+		problem  string
+	}{
+		{"required STARTTLS", "true", "", "does not support STARTTLS"},
+		{"authentication", "", "rollcall", "does not support authentication"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			notifier := &EmailNotifier{
+				Host:     host,
+				User:     test.user,
+				Password: "secret",
+				StartTLS: test.startTLS,
+				From:     "rollcall@example.com",
+				To:       []string{"security@example.com"},
+			}
+			err := notifier.Notify(&Report{Title: "test", Text: "test"})
+			if err == nil || !strings.Contains(err.Error(), test.problem) {
+				t.Fatalf("got %v, want %q", err, test.problem)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/**
+Mention escalation of suspect members and raids.
+
+	Who gets pinged is chosen by the highest severity among the escalated
+	events, from the "escalation" section of the rules file:
+
+	"escalation": {
+		"critical": ["channel", "S0123ABCD"],
+		"high": ["here"],
+		"default": ["none"]
+	}
+
+	Targets are everyone, channel or here, user IDs (U...), user group IDs
+	(S...) or none. Severities without targets fall back to "default" and
+	then to --escalate.
+**/
+
+var escalateTargets = []string{"everyone"}
+
+var slackIDPattern = regexp.MustCompile(`^[UWS][A-Z0-9]{2,}$`)
+
+// escalationMention returns the mentions for the escalated events, followed
+// by a space, or an empty string when nobody is to be pinged
+func escalationMention(events []*Event) string {
+	severity := ""
+	for _, event := range events {
+		if severityRank(event.Severity) > severityRank(severity) {
+			severity = event.Severity
+		}
+	}
+
+	targets := escalateTargets
+	if ruleSet != nil && ruleSet.Escalation != nil {
+		if configured, ok := ruleSet.Escalation[strings.ToLower(severity)]; ok && severity != "" {
+			targets = configured
+		} else if configured, ok := ruleSet.Escalation["default"]; ok {
+			targets = configured
+		}
+	}
+
+	mentions := []string{}
+	for _, target := range targets {
+		if mention, _ := formatMention(target); mention != "" {
+			mentions = append(mentions, mention)
+		}
+	}
+	if len(mentions) == 0 {
+		return ""
+	}
+
+	return strings.Join(mentions, " ") + " "
+}
+
+// formatMention converts an escalation target to Slack's mention syntax
+func formatMention(target string) (string, error) {
+	target = strings.TrimSpace(target)
+	switch strings.ToLower(strings.TrimPrefix(target, "@")) {
+	case "", "none":
+		return "", nil
+	case "everyone", "channel", "here":
+		return "<!" + strings.ToLower(strings.TrimPrefix(target, "@")) + ">", nil
+	}
+
+	if strings.HasPrefix(target, "<") && strings.HasSuffix(target, ">") {
+		return target, nil
+	}
+
+	target = strings.TrimPrefix(target, "subteam^")
+	if slackIDPattern.MatchString(target) {
+		if target[0] == 'S' {
+			return "<!subteam^" + target + ">", nil
+		}
+		return "<@" + target + ">", nil
+	}
+
+	return "", fmt.Errorf("unknown escalation target %q", target)
+}
+
+// checkEscalation makes sure every severity and target can be used
+func checkEscalation(escalation map[string][]string) error {
+	for severity, targets := range escalation {
+		if severity != "default" && severityRank(severity) < 0 {
+			return fmt.Errorf("escalation: unknown severity %q", severity)
+		}
+		for _, target := range targets {
+			if _, err := formatMention(target); err != nil {
+				return fmt.Errorf("escalation: %v", err)
+			}
+		}
+	}
+	return nil
+}
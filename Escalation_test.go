@@ -0,0 +1,90 @@
+package main
+
+import "testing"
+
+func TestFormatMention(t *testing.T) {
+	tests := []struct {
+		target  string
+		mention string
+		valid   bool
+	}{
+		{"everyone", "<!everyone>", true},
+		{"@here", "<!here>", true},
+		{"Channel", "<!channel>", true},
+		{" here ", "<!here>", true},
+		{"U0123ABCD", "<@U0123ABCD>", true},
+		{"W0123ABCD", "<@W0123ABCD>", true},
+		{"S0123ABCD", "<!subteam^S0123ABCD>", true},
+		{"subteam^S0123ABCD", "<!subteam^S0123ABCD>", true},
+		{"<!subteam^S0123ABCD|security>", "<!subteam^S0123ABCD|security>", true},
+		{"none", "", true},
+		{"", "", true},
+		{"bob", "", false},
+		{"u0123abcd", "", false},
+		{"C0123ABCD", "", false},
+	}
+
+	for _, test := range tests {
+		mention, err := formatMention(test.target)
+		if valid := err == nil; valid != test.valid || mention != test.mention {
+			t.Errorf("%q: got %q, %v, want %q", test.target, mention, err, test.mention)
+		}
+	}
+}
+
+func TestEscalationMention(t *testing.T) {
+	tests := []struct {
+		name       string
+		escalate   []string
+		escalation map[string][]string
+		severities []string
+		mention    string
+	}{
+		{"default", []string{"everyone"}, nil, []string{""}, "<!everyone> "},
+		{"flag targets", []string{"here", "U0123ABCD"}, nil, []string{"critical"}, "<!here> <@U0123ABCD> "},
+		{"nobody", []string{"none"}, nil, []string{"high"}, ""},
+		{"per severity", []string{"everyone"}, map[string][]string{"critical": {"channel", "S0123ABCD"}, "high": {"here"}}, []string{"high", "critical"}, "<!channel> <!subteam^S0123ABCD> "},
+		{"highest severity wins", []string{"everyone"}, map[string][]string{"high": {"here"}, "medium": {"U0123ABCD"}}, []string{"medium", "high", "low"}, "<!here> "},
+		{"severity without targets", []string{"everyone"}, map[string][]string{"critical": {"channel"}, "default": {"U0123ABCD"}}, []string{"high"}, "<@U0123ABCD> "},
+		{"no default", []string{"here"}, map[string][]string{"critical": {"channel"}}, []string{"high"}, "<!here> "},
+		{"no severity", []string{"everyone"}, map[string][]string{"critical": {"channel"}, "default": {"none"}}, []string{""}, ""},
+	}
+
+	t.Cleanup(func() {
+		escalateTargets = []string{"everyone"}
+		ruleSet = nil
+	})
+
+	for _, test := range tests {
+		escalateTargets = test.escalate
+		ruleSet = nil
+		if test.escalation != nil {
+			ruleSet = &RuleSet{Escalation: test.escalation}
+		}
+
+		events := []*Event{}
+		for _, severity := range test.severities {
+			events = append(events, &Event{Type: EventSuspectMember, Severity: severity})
+		}
+		if mention := escalationMention(events); mention != test.mention {
+			t.Errorf("%s: got %q, want %q", test.name, mention, test.mention)
+		}
+	}
+}
+
+func TestCheckEscalation(t *testing.T) {
+	tests := []struct {
+		escalation map[string][]string
+		valid      bool
+	}{
+		{map[string][]string{"critical": {"channel"}, "default": {"none"}}, true},
+		{map[string][]string{"huge": {"here"}}, false},
+		{map[string][]string{"high": {"bob"}}, false},
+	}
+
+	for _, test := range tests {
+		if valid := checkEscalation(test.escalation) == nil; valid != test.valid {
+			t.Errorf("%v: valid is %v", test.escalation, valid)
+		}
+	}
+}
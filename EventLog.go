@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/**
+Event log.
+
+	With --eventlog every reported event is appended to a file, one JSON
+	event per line, keeping the change history the dashboard's timeline and
+	member pages are built from.
+**/
+
+var eventLogFile = ""
+
+// appendEventLog adds the events to the end of the event log
+func appendEventLog(events []*Event) error {
+	if eventLogFile == "" || len(events) == 0 {
+		return nil
+	}
+
+	if dryRun {
+		showDryRun(fmt.Sprintf("would append %d events to %s", len(events), eventLogFile), nil)
+		return nil
+	}
+
+	handle, err := os.OpenFile(eventLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	encoder := json.NewEncoder(handle)
+	for _, event := range events {
+		if err = encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadEventLog reads every event of the event log, oldest first
+func loadEventLog() ([]*Event, error) {
+	events := []*Event{}
+	if eventLogFile == "" {
+		return events, nil
+	}
+
+	handle, err := os.Open(eventLogFile)
+	if os.IsNotExist(err) {
+		return events, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	scanner := bufio.NewScanner(handle)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		event := &Event{}
+		if err = json.Unmarshal([]byte(line), event); err != nil {
+			return nil, fmt.Errorf("invalid event in %s: %v", eventLogFile, err)
+		}
+		events = append(events, event)
+	}
+
+	return events, scanner.Err()
+}
+
+// involves reports whether the event is about the member
+func (event *Event) involves(userID string) bool {
+	if (event.User != nil && event.User.ID == userID) || (event.Previous != nil && event.Previous.ID == userID) {
+		return true
+	}
+	for _, user := range event.Users {
+		if user.ID == userID {
+			return true
+		}
+	}
+	return false
+}
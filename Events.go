@@ -0,0 +1,61 @@
+package main
+
+import (
+	"time"
+
+	"github.com/yepher/SlackRollCall/conversations"
+)
+
+// Event types reported while comparing the cached and current member and channel lists
+const (
+	EventMemberJoined   = "member_joined"
+	EventMemberMissing  = "member_missing"
+	EventMemberDeleted  = "member_deleted"
+	EventMemberRestored = "member_restored"
+	EventSuspectMember  = "suspect_member"
+	EventRoleChanged    = "member_role_changed"
+	EventEmailChanged   = "member_email_changed"
+	EventMemberRaid     = "member_raid"
+	EventRaidOver       = "member_raid_over"
+
+	EventChannelCreated    = conversations.Created
+	EventChannelRemoved    = conversations.Removed
+	EventChannelArchived   = conversations.Archived
+	EventChannelUnarchived = conversations.Unarchived
+	EventChannelRenamed    = conversations.Renamed
+)
+
+// Event describes a single change found by dumpDelta or dumpChannelDelta
+type Event struct {
+	Type     string                 `json:"type"`
+	Time     time.Time              `json:"time"`
+	User     *User                  `json:"user,omitempty"`
+	Users    []*User                `json:"users,omitempty"`
+	Previous *User                  `json:"previous,omitempty"`
+	Channel  *conversations.Channel `json:"channel,omitempty"`
+	Text     string                 `json:"text"`
+	Reasons  []string               `json:"reasons,omitempty"`
+	Score    int                    `json:"score,omitempty"`
+	Severity string                 `json:"severity,omitempty"`
+	Tags     []string               `json:"tags,omitempty"`
+	Routes   []string               `json:"routes,omitempty"`
+}
+
+func newMemberEvent(eventType string, current *User, previous *User, text string) *Event {
+	return &Event{
+		Type:     eventType,
+		Time:     time.Now().UTC(),
+		User:     current,
+		Previous: previous,
+		Text:     text,
+	}
+}
+
+func newChannelEvent(eventType string, channel *conversations.Channel, text string) *Event {
+	return &Event{
+		Type:    eventType,
+		Time:    time.Now().UTC(),
+		Channel: channel,
+		Text:    text,
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+Health tracking and the dead man's switch.
+
+	The last attempt, success and failure of the members and channels checks
+	are kept, in --status when set so they survive between cron runs.
+	--serve exposes them on /healthz, which answers while the process runs,
+	and /readyz, which fails until every check has succeeded and whenever a
+	check last failed or, with --deadman, has not succeeded within the window.
+
+	With --deadman 26h every destination is told once when no check succeeded
+	for 26 hours, and again when runs recover.
+**/
+
+// CheckStatus is the run history of one check
+type CheckStatus struct {
+	LastAttempt *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// HealthStatus is the content of the status file and of /readyz. Started
+// is the first run kept in the status file, or the process start without one.
+type HealthStatus struct {
+	Started        time.Time               `json:"started"`
+	Checks         map[string]*CheckStatus `json:"checks"`
+	DeadmanAlerted bool                    `json:"deadman_alerted,omitempty"`
+}
+
+var statusFile = ""
+var deadmanWindow time.Duration
+var health = &HealthStatus{Started: time.Now().UTC(), Checks: map[string]*CheckStatus{}}
+var healthLock sync.Mutex
+
+// loadHealth picks up the run history saved by previous runs
+func loadHealth() error {
+	if statusFile == "" {
+		return nil
+	}
+
+	contents, err := ioutil.ReadFile(statusFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	healthLock.Lock()
+	defer healthLock.Unlock()
+
+	saved := &HealthStatus{}
+	if err = json.Unmarshal(contents, saved); err != nil {
+		return fmt.Errorf("%s: %v", statusFile, err)
+	}
+	if saved.Checks != nil {
+		health.Checks = saved.Checks
+	}
+	// Cron runs are short lived, so the window counts from the first run
+	if !saved.Started.IsZero() {
+		health.Started = saved.Started
+	}
+	health.DeadmanAlerted = saved.DeadmanAlerted
+	return nil
+}
+
+// recordCheck records the outcome of a check run and returns err
+func recordCheck(name string, err error) error {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+
+	now := time.Now().UTC()
+	status, ok := health.Checks[name]
+	if !ok {
+		status = &CheckStatus{}
+		health.Checks[name] = status
+	}
+
+	status.LastAttempt = &now
+	if err != nil {
+		status.LastFailure = &now
+		status.LastError = err.Error()
+	} else {
+		status.LastSuccess = &now
+		status.LastError = ""
+	}
+
+	saveHealth()
+	return err
+}
+
+// saveHealth writes the status file, the health lock must be held
+func saveHealth() {
+	if statusFile == "" {
+		return
+	}
+
+	json, _ := json.MarshalIndent(health, "", "\t")
+	writeCache(statusFile, json)
+}
+
+// lastSuccess returns the latest success of any check, or the start time
+// when there was none
+func lastSuccess() time.Time {
+	latest := health.Started
+	for _, status := range health.Checks {
+		if status.LastSuccess != nil && status.LastSuccess.After(latest) {
+			latest = *status.LastSuccess
+		}
+	}
+	return latest
+}
+
+// unready lists why the checks are not healthy, the health lock must be held
+func unready() []string {
+	problems := []string{}
+	if len(health.Checks) == 0 {
+		problems = append(problems, "no check has run yet")
+	}
+
+	names := []string{}
+	for name := range health.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		status := health.Checks[name]
+		switch {
+		case status.LastSuccess == nil:
+			problems = append(problems, name+" never succeeded: "+status.LastError)
+		case status.LastError != "":
+			problems = append(problems, name+" failed: "+status.LastError)
+		case deadmanWindow > 0 && time.Since(*status.LastSuccess) > deadmanWindow:
+			problems = append(problems, fmt.Sprintf("%s has not succeeded since %s", name, status.LastSuccess.Format(time.RFC3339)))
+		}
+	}
+
+	return problems
+}
+
+// checkDeadman notifies every destination once when no check succeeded within
+// the window, and once more when a check succeeds again
+func checkDeadman() {
+	if deadmanWindow <= 0 {
+		return
+	}
+
+	healthLock.Lock()
+	since := lastSuccess()
+	overdue := time.Since(since) > deadmanWindow
+	alerted := health.DeadmanAlerted
+	lastError := ""
+	for _, status := range health.Checks {
+		if status.LastError != "" {
+			lastError = status.LastError
+		}
+	}
+
+	if overdue != alerted {
+		health.DeadmanAlerted = overdue
+		saveHealth()
+	}
+	healthLock.Unlock()
+
+	switch {
+	case overdue && !alerted:
+		text := fmt.Sprintf("WARNING no successful roll call since %s.", since.Local().Format(time.RFC1123))
+		if lastError != "" {
+			text = fmt.Sprintf("%s\nLast error: %s", text, lastError)
+		}
+		slog.Warn("no successful run", "since", since.Format(time.RFC3339), "error", lastError)
+		notifyAll(notifiers, &Report{Title: "Slack Roll Call: no successful run", Text: text, Events: []*Event{}})
+	case !overdue && alerted:
+		text := "Roll call runs are succeeding again."
+		slog.Info("runs recovered")
+		notifyAll(notifiers, &Report{Title: "Slack Roll Call: runs recovered", Text: text, Events: []*Event{}})
+	}
+}
+
+// watchDeadman checks the dead man's switch until stop is closed
+func watchDeadman(stop <-chan struct{}) {
+	interval := deadmanWindow / 10
+	if interval > 5*time.Minute {
+		interval = 5 * time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			checkDeadman()
+		}
+	}
+}
+
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, "ok")
+}
+
+func serveReadyz(w http.ResponseWriter, r *http.Request) {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+
+	problems := unready()
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(problems) > 0 {
+		w.Header().Set("X-Problems", strings.Join(problems, "; "))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
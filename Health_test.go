@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// reportRecorder is a Notifier that keeps every report
+type reportRecorder struct {
+	reports []*Report
+}
+
+func (recorder *reportRecorder) Name() string {
+	return "Recorder"
+}
+
+func (recorder *reportRecorder) Notify(report *Report) error {
+	recorder.reports = append(recorder.reports, report)
+	return nil
+}
+
+// resetHealth starts a fresh process' health state with a status file
+func resetHealth(t *testing.T) {
+	statusFile = filepath.Join(t.TempDir(), "status.json")
+	deadmanWindow = 0
+	health = &HealthStatus{Started: time.Now().UTC(), Checks: map[string]*CheckStatus{}}
+	t.Cleanup(func() {
+		statusFile = ""
+		deadmanWindow = 0
+		notifiers = []Notifier{}
+	})
+}
+
+func TestReadyz(t *testing.T) {
+	resetHealth(t)
+
+	tests := []struct {
+		name  string
+		err   error
+		ready int
+	}{
+		{"before any run", nil, 503},
+		{"after a success", nil, 200},
+		{"after a failure", errors.New("invalid_auth"), 503},
+		{"after recovering", nil, 200},
+	}
+
+	for i, test := range tests {
+		if i > 0 {
+			recordCheck("members", test.err)
+		}
+		recorder := httptest.NewRecorder()
+		serveReadyz(recorder, httptest.NewRequest("GET", "/readyz", nil))
+		if recorder.Code != test.ready {
+			t.Errorf("%s: got %d, want %d", test.name, recorder.Code, test.ready)
+		}
+	}
+}
+
+func TestDeadmanFiresForCronRuns(t *testing.T) {
+	resetHealth(t)
+
+	// A status file left by cron runs whose last success was two days ago
+	lastSuccess := time.Now().UTC().Add(-48 * time.Hour)
+	saved := &HealthStatus{
+		Started: lastSuccess.Add(-24 * time.Hour),
+		Checks:  map[string]*CheckStatus{"members": {LastAttempt: &lastSuccess, LastSuccess: &lastSuccess}},
+	}
+	contents, _ := json.Marshal(saved)
+	if err := ioutil.WriteFile(statusFile, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &reportRecorder{}
+	notifiers = []Notifier{recorder}
+	deadmanWindow = 26 * time.Hour
+
+	// This run's process has just started and fails
+	if err := loadHealth(); err != nil {
+		t.Fatal(err)
+	}
+	recordCheck("members", errors.New("token_revoked"))
+	checkDeadman()
+
+	if !health.DeadmanAlerted || len(recorder.reports) != 1 {
+		t.Fatalf("dead man's switch did not fire: alerted %v, %d reports", health.DeadmanAlerted, len(recorder.reports))
+	}
+
+	// The next failing cron run does not alert again
+	health = &HealthStatus{Started: time.Now().UTC(), Checks: map[string]*CheckStatus{}}
+	loadHealth()
+	recordCheck("members", errors.New("token_revoked"))
+	checkDeadman()
+	if len(recorder.reports) != 1 {
+		t.Fatalf("alerted %d times", len(recorder.reports))
+	}
+
+	// A successful run reports the recovery once
+	recordCheck("members", nil)
+	checkDeadman()
+	if health.DeadmanAlerted || len(recorder.reports) != 2 {
+		t.Fatalf("recovery not reported: alerted %v, %d reports", health.DeadmanAlerted, len(recorder.reports))
+	}
+}
+
+func TestDeadmanWaitsForFirstWindow(t *testing.T) {
+	resetHealth(t)
+	recorder := &reportRecorder{}
+	notifiers = []Notifier{recorder}
+	deadmanWindow = 26 * time.Hour
+
+	recordCheck("members", errors.New("ratelimited"))
+	checkDeadman()
+	if health.DeadmanAlerted || len(recorder.reports) != 0 {
+		t.Fatal("alerted before the window passed")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+/**
+Impersonation detection.
+
+	New members, and members who change their name, are compared against the
+	workspace admins and owners and the --vip names. Names are folded through
+	the same confusable table as lookalike domains, stripped of everything but
+	letters and digits and compared both as written and with their words
+	sorted, so "Jane Smith", "jane.smith", "Smith, Jane" and "Jаne Smlth"
+	all match. A match from a different account is a critical alert. A VIP
+	entry may carry the VIP's own user ID after a colon; without one, an
+	admin or owner with the VIP's name is taken to be the VIP.
+**/
+
+var checkImpersonation = false
+var vipNames = []string{}
+
+// ProtectedIdentity is an admin, owner or VIP name that others may impersonate
+type ProtectedIdentity struct {
+	ID    string
+	Label string
+	names []string
+}
+
+func protectedIdentities(members *MemberList) []*ProtectedIdentity {
+	identities := []*ProtectedIdentity{}
+	if !checkImpersonation {
+		return identities
+	}
+
+	for _, member := range members.Members {
+		if member.Deleted || member.IsBot || memberRole(member) == "Member" {
+			continue
+		}
+
+		identities = append(identities, &ProtectedIdentity{
+			ID:    member.ID,
+			Label: fmt.Sprintf("%s (%s, %s)", displayName(member), memberRole(member), member.ID),
+			names: comparableNames(member.Name, member.RealName, member.Profile.RealName, member.Profile.RealNameNormalized),
+		})
+	}
+
+	admins := identities
+	for _, vip := range vipNames {
+		// A VIP may name its own account as "Jane Smith:U024BE7LH"
+		name, id, _ := strings.Cut(vip, ":")
+		identity := &ProtectedIdentity{
+			ID:    strings.TrimSpace(id),
+			Label: fmt.Sprintf("%s (VIP)", strings.TrimSpace(name)),
+			names: comparableNames(name),
+		}
+
+		// Otherwise an admin or owner of the same name is the VIP themselves
+		for _, admin := range admins {
+			if identity.ID == "" && namesOverlap(admin.names, identity.names) {
+				identity.ID = admin.ID
+			}
+		}
+
+		identities = append(identities, identity)
+	}
+
+	return identities
+}
+
+// impersonationReason describes which protected identity the user's names
+// are a near duplicate of, or returns an empty string
+func impersonationReason(user *User, identities []*ProtectedIdentity) string {
+	names := comparableNames(user.Name, user.RealName, user.Profile.RealName, user.Profile.RealNameNormalized)
+
+	for _, identity := range identities {
+		if identity.ID == user.ID {
+			continue
+		}
+
+		if namesOverlap(names, identity.names) {
+			return "impersonates " + identity.Label
+		}
+	}
+
+	return ""
+}
+
+// namesOverlap reports whether any of the names matches any of the others
+func namesOverlap(names []string, others []string) bool {
+	for _, name := range names {
+		for _, other := range others {
+			if namesMatch(name, other) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// comparableNames folds each name and returns it both as written and with
+// its words sorted
+func comparableNames(names ...string) []string {
+	comparable := []string{}
+	for _, name := range names {
+		words := strings.FieldsFunc(foldConfusables(name), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if len(strings.Join(words, "")) < 4 {
+			continue
+		}
+
+		comparable = appendUnique(comparable, strings.Join(words, ""))
+		sort.Strings(words)
+		comparable = appendUnique(comparable, strings.Join(words, ""))
+	}
+	return comparable
+}
+
+func namesMatch(name string, protected string) bool {
+	if name == protected {
+		return true
+	}
+
+	allowed := 0
+	if len(protected) >= 6 {
+		allowed = 1
+	}
+	if len(protected) >= 11 {
+		allowed = 2
+	}
+
+	return editDistance(name, protected) <= allowed
+}
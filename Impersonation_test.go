@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestImpersonationReason(t *testing.T) {
+	checkImpersonation = true
+	vipNames = []string{"Tim Cook:U3", "Jane Smith"}
+	t.Cleanup(func() {
+		checkImpersonation = false
+		vipNames = []string{}
+	})
+
+	owner := &User{ID: "U1", Name: "jane.smith", RealName: "Jane Smith", IsOwner: true}
+	identities := protectedIdentities(&MemberList{Members: []*User{owner, {ID: "U2", RealName: "Joe Member"}}})
+
+	tests := []struct {
+		id           string
+		name         string
+		impersonates bool
+	}{
+		{"U9", "Jane Smith", true},
+		{"U9", "Smith, Jane", true},
+		{"U9", "Jаne Smlth", true},
+		{"U9", "Jane Smyth", true},
+		{"U9", "T1m C00k", true},
+		{"U9", "Joe Member", false},
+		{"U9", "Janet Smithers", false},
+		{"U9", "Bob", false},
+		// The protected people themselves
+		{"U1", "Jane Smith", false},
+		{"U3", "Tim Cook", false},
+	}
+
+	for _, test := range tests {
+		reason := impersonationReason(&User{ID: test.id, RealName: test.name}, identities)
+		if (reason != "") != test.impersonates {
+			t.Errorf("%s %q: got %q", test.id, test.name, reason)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+)
+
+/**
+Logging.
+
+	The logger itself is set up by the logging package. --logformat is text
+	or json, --loglevel is debug, info, warn or error, and --verbose true is
+	the same as --loglevel debug.
+
+	Emails and phone numbers are masked in the log unless --logpii true.
+**/
+
+var logPII = false
+
+// redact keeps the first character of value unless --logpii is set
+func redact(value string) string {
+	runes := []rune(value)
+	if logPII || len(runes) == 0 {
+		return value
+	}
+	return string(runes[0]) + "***"
+}
+
+// redactEmail masks the mailbox of an email but keeps its domain
+func redactEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if logPII || at < 0 {
+		return redact(email)
+	}
+	return redact(email[:at]) + email[at:]
+}
+
+// userAttr is the loggable part of a member record
+func userAttr(key string, user *User) slog.Attr {
+	if user == nil {
+		return slog.Attr{Key: key, Value: slog.StringValue("none")}
+	}
+	return slog.Group(key,
+		"id", user.ID,
+		"name", user.Name,
+		"email", redactEmail(user.Profile.Email),
+		"phone", redact(user.Profile.Phone),
+		"deleted", user.Deleted,
+		"bot", user.IsBot,
+		"role", memberRole(user))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		email    string
+		redacted string
+		pii      string
+	}{
+		{"jane.doe@example.com", "j***@example.com", "jane.doe@example.com"},
+		{"élise@example.fr", "é***@example.fr", "élise@example.fr"},
+		{"@example.com", "@example.com", "@example.com"},
+		{"not an email", "n***", "not an email"},
+		{"", "", ""},
+	}
+
+	t.Cleanup(func() { logPII = false })
+
+	for _, test := range tests {
+		logPII = false
+		if redacted := redactEmail(test.email); redacted != test.redacted {
+			t.Errorf("redactEmail(%q) = %q, want %q", test.email, redacted, test.redacted)
+		}
+		logPII = true
+		if kept := redactEmail(test.email); kept != test.pii {
+			t.Errorf("redactEmail(%q) with --logpii = %q, want %q", test.email, kept, test.pii)
+		}
+	}
+}
+
+func TestUserAttrMasksContactDetails(t *testing.T) {
+	user := testUser("U1", "jane", "jane.doe@example.com")
+	user.Profile.Phone = "+1 555 0100"
+
+	var out bytes.Buffer
+	slog.New(slog.NewJSONHandler(&out, nil)).Info("member", userAttr("user", user), userAttr("previous", nil))
+	logged := out.String()
+
+	for _, want := range []string{`"id":"U1"`, `"name":"jane"`, `"email":"j***@example.com"`, `"phone":"+***"`, `"previous":"none"`} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("missing %s in %s", want, logged)
+		}
+	}
+	if strings.Contains(logged, "jane.doe") || strings.Contains(logged, "555") {
+		t.Errorf("contact details logged: %s", logged)
+	}
+}
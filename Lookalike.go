@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+/**
+Lookalike domain detection.
+
+	New members' email domains are compared against the legitimate domains
+	given with --lookalike. Internationalized (xn--) domains are decoded and
+	every domain is folded through a table of confusable characters (Cyrillic
+	and Greek letters, digits, accents, rn/vv pairs) before comparing, so
+	0urcompany.com and the Cyrillic оurcompany.com both fold to ourcompany.com.
+
+	A domain is reported when it folds to a legitimate domain, is within
+	--lookalikedistance edits of one, keeps the name with another top level
+	domain (ourcompany.co) or contains the name (ourcompany-support.com).
+**/
+
+var lookalikeDomains = []string{}
+var lookalikeDistance = 2
+
+// confusables maps characters that look like a latin letter to that letter
+var confusables = map[rune]string{
+	// Cyrillic
+	'а': "a", 'в': "b", 'е': "e", 'ё': "e", 'һ': "h", 'і': "i", 'ї': "i", 'ј': "j",
+	'к': "k", 'ӏ': "l", 'м': "m", 'н': "h", 'о': "o", 'р': "p", 'ԛ': "q", 'с': "c",
+	'ѕ': "s", 'т': "t", 'у': "y", 'ԝ': "w", 'х': "x", 'ԁ': "d", 'ɡ': "g", 'п': "n",
+	// Greek
+	'α': "a", 'β': "b", 'ε': "e", 'η': "n", 'ι': "i", 'κ': "k", 'ν': "v", 'ο': "o",
+	'ρ': "p", 'τ': "t", 'υ': "u", 'χ': "x", 'ω': "w",
+	// Latin with marks or lookalike shapes
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ç': "c", 'è': "e",
+	'é': "e", 'ê': "e", 'ë': "e", 'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ı': "i",
+	'ł': "l", 'ñ': "n", 'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ý': "y", 'ÿ': "y",
+	// Digits
+	'0': "o", '1': "l", '3': "e", '5': "s",
+}
+
+// confusablePairs are letter pairs that read as a single letter
+var confusablePairs = strings.NewReplacer("rn", "m", "vv", "w", "cl", "d")
+
+// lookalikeReason describes why the domain of an email looks like one of the
+// legitimate domains, or returns an empty string when it does not
+func lookalikeReason(email string) string {
+	domain := decodeDomain(emailDomain(email))
+	if domain == "" {
+		return ""
+	}
+
+	for _, legitimate := range lookalikeDomains {
+		if domain == legitimate || strings.HasSuffix(domain, "."+legitimate) {
+			return ""
+		}
+	}
+
+	folded := foldConfusables(domain)
+	name := domainName(folded)
+
+	for _, legitimate := range lookalikeDomains {
+		legitimateFolded := foldConfusables(legitimate)
+		legitimateName := domainName(legitimateFolded)
+
+		// Short domains are only a letter or two apart from each other anyway
+		maxDistance := lookalikeDistance
+		if len(legitimateFolded) < 8 && maxDistance > 1 {
+			maxDistance = 1
+		}
+
+		switch {
+		case folded == legitimateFolded:
+			return fmt.Sprintf("lookalike of %s (confusable characters)", legitimate)
+		case editDistance(folded, legitimateFolded) <= maxDistance:
+			return fmt.Sprintf("lookalike of %s (typo)", legitimate)
+		case name == legitimateName:
+			return fmt.Sprintf("lookalike of %s (other top level domain)", legitimate)
+		case len(legitimateName) >= 5 && strings.Contains(folded, legitimateName):
+			return fmt.Sprintf("lookalike of %s (contains name)", legitimate)
+		}
+	}
+
+	return ""
+}
+
+// foldConfusables lower cases text and replaces confusable characters with
+// the latin letters they look like
+func foldConfusables(text string) string {
+	var folded strings.Builder
+	for _, r := range strings.ToLower(text) {
+		if r >= 'ａ' && r <= 'ｚ' {
+			r = r - 'ａ' + 'a'
+		}
+
+		if replacement, ok := confusables[r]; ok {
+			folded.WriteString(replacement)
+		} else if !unicode.Is(unicode.Mn, r) {
+			folded.WriteRune(r)
+		}
+	}
+	return confusablePairs.Replace(folded.String())
+}
+
+// domainName returns the label before the top level domain, e.g.
+// ourcompany for mail.ourcompany.com
+func domainName(domain string) string {
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return domain
+	}
+	return labels[len(labels)-2]
+}
+
+// decodeDomain converts xn-- labels of an internationalized domain back to
+// unicode, leaving labels that fail to decode as they are
+func decodeDomain(domain string) string {
+	labels := strings.Split(domain, ".")
+	for i, label := range labels {
+		if strings.HasPrefix(label, "xn--") {
+			if decoded, err := decodePunycode(label[4:]); err == nil {
+				labels[i] = decoded
+			}
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// editDistance is the optimal string alignment distance between a and b:
+// insertions, deletions, substitutions and swaps of neighbours each count as one
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	rows := make([][]int, len(ra)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(rb)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			best := rows[i-1][j] + 1
+			if rows[i][j-1]+1 < best {
+				best = rows[i][j-1] + 1
+			}
+			if rows[i-1][j-1]+cost < best {
+				best = rows[i-1][j-1] + cost
+			}
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] && rows[i-2][j-2]+1 < best {
+				best = rows[i-2][j-2] + 1
+			}
+			rows[i][j] = best
+		}
+	}
+
+	return rows[len(ra)][len(rb)]
+}
+
+// Punycode parameters from RFC 3492
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+func decodePunycode(encoded string) (string, error) {
+	output := []rune{}
+	if delimiter := strings.LastIndex(encoded, "-"); delimiter >= 0 {
+		output = []rune(encoded[:delimiter])
+		encoded = encoded[delimiter+1:]
+	}
+
+	n, i, bias := punyInitialN, 0, punyInitialBias
+	for pos := 0; pos < len(encoded); {
+		oldI, weight := i, 1
+		for k := punyBase; ; k += punyBase {
+			if pos >= len(encoded) {
+				return "", fmt.Errorf("truncated punycode")
+			}
+
+			digit := punycodeDigit(encoded[pos])
+			pos++
+			if digit < 0 {
+				return "", fmt.Errorf("invalid punycode digit %q", encoded[pos-1])
+			}
+
+			i += digit * weight
+			if i > unicode.MaxRune*(len(output)+1) {
+				return "", fmt.Errorf("punycode overflow")
+			}
+
+			t := k - bias
+			if t < punyTMin {
+				t = punyTMin
+			} else if t > punyTMax {
+				t = punyTMax
+			}
+			if digit < t {
+				break
+			}
+			weight *= punyBase - t
+		}
+
+		bias = punycodeAdapt(i-oldI, len(output)+1, oldI == 0)
+		n += i / (len(output) + 1)
+		i %= len(output) + 1
+		if n > unicode.MaxRune {
+			return "", fmt.Errorf("punycode overflow")
+		}
+
+		output = append(output, 0)
+		copy(output[i+1:], output[i:])
+		output[i] = rune(n)
+		i++
+	}
+
+	return string(output), nil
+}
+
+func punycodeDigit(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c-'0') + 26
+	case c >= 'a' && c <= 'z':
+		return int(c - 'a')
+	case c >= 'A' && c <= 'Z':
+		return int(c - 'A')
+	}
+	return -1
+}
+
+func punycodeAdapt(delta int, numPoints int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodePunycode(t *testing.T) {
+	tests := []struct {
+		encoded string
+		decoded string
+		problem string
+	}{
+		{"mnchen-3ya", "münchen", ""},
+		{"bcher-kva", "bücher", ""},
+		{"e1afmkfd", "пример", ""},
+		{"p1ai", "рф", ""},
+		{"fiqs8s", "中国", ""},
+		{"80ak6aa92e", "аррӏе", ""},
+		{"egbpdaj6bu4bxfgehfvwxn", "ليهمابتكلموشعربي؟", ""},
+		{"3B-ww4c5e180e575a65lsy2b", "3年B組金八先生", ""},
+		{"-> $1.00 <--", "-> $1.00 <-", ""},
+		{"ourcompany-", "ourcompany", ""},
+		{"", "", ""},
+		{"mnchen-3y!", "", "invalid punycode digit"},
+		{"mnchen-99", "", "truncated punycode"},
+		{"999999999999", "", "punycode overflow"},
+	}
+
+	for _, test := range tests {
+		decoded, err := decodePunycode(test.encoded)
+		switch {
+		case test.problem != "" && (err == nil || !strings.Contains(err.Error(), test.problem)):
+			t.Errorf("decodePunycode(%q) = %q, %v, want %q", test.encoded, decoded, err, test.problem)
+		case test.problem == "" && (err != nil || decoded != test.decoded):
+			t.Errorf("decodePunycode(%q) = %q, %v, want %q", test.encoded, decoded, err, test.decoded)
+		}
+	}
+}
+
+func TestDecodeDomain(t *testing.T) {
+	tests := []struct {
+		domain  string
+		decoded string
+	}{
+		{"ourcompany.com", "ourcompany.com"},
+		{"xn--e1afmkfd.xn--p1ai", "пример.рф"},
+		{"mail.xn--mnchen-3ya.de", "mail.münchen.de"},
+		{"xn--mnchen-3y!.de", "xn--mnchen-3y!.de"},
+	}
+
+	for _, test := range tests {
+		if decoded := decodeDomain(test.domain); decoded != test.decoded {
+			t.Errorf("decodeDomain(%q) = %q, want %q", test.domain, decoded, test.decoded)
+		}
+	}
+}
+
+func TestLookalikeReason(t *testing.T) {
+	lookalikeDomains = []string{"ourcompany.com", "acme.io"}
+	t.Cleanup(func() { lookalikeDomains = []string{} })
+
+	tests := []struct {
+		email     string
+		lookalike bool
+	}{
+		{"ann@ourcompany.com", false},
+		{"ann@mail.ourcompany.com", false},
+		{"ann@gmail.com", false},
+		{"ann@apex.io", false},
+		{"ann@0urcompany.com", true},
+		{"ann@xn--urcompany-1bi.com", true},
+		{"ann@оurcompany.com", true},
+		{"ann@ourcornpany.com", true},
+		{"ann@ourcompnay.com", true},
+		{"ann@ourcompany.co", true},
+		{"ann@ourcompany-support.com", true},
+		{"ann@acne.io", true},
+		{"ann@acme.co", true},
+	}
+
+	for _, test := range tests {
+		if reason := lookalikeReason(test.email); (reason != "") != test.lookalike {
+			t.Errorf("lookalikeReason(%q) = %q", test.email, reason)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import "fmt"
+
+/**
+Mattermost Incoming Webhook: https://developers.mattermost.com/integrate/webhooks/incoming/
+	Example: https://mattermost.example.com/hooks/xxxxxxxxxxxxxxxxxxxxxxxxxx
+
+	Each event becomes a message attachment with its details as short fields.
+**/
+
+// MattermostNotifier posts the report to a Mattermost incoming webhook
+type MattermostNotifier struct {
+	URL string
+}
+
+// MattermostMessage is the body accepted by a Mattermost incoming webhook
+type MattermostMessage struct {
+	Username    string                  `json:"username"`
+	Text        string                  `json:"text"`
+	Attachments []*MattermostAttachment `json:"attachments"`
+}
+
+// MattermostAttachment describes a single event in a MattermostMessage
+type MattermostAttachment struct {
+	Fallback string             `json:"fallback"`
+	Color    string             `json:"color"`
+	Title    string             `json:"title"`
+	Fields   []*MattermostField `json:"fields"`
+}
+
+// MattermostField is a title/value row in a MattermostAttachment
+type MattermostField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// Name identifies the notifier in error messages
+func (notifier *MattermostNotifier) Name() string {
+	return "Mattermost"
+}
+
+// Notify posts a message with an attachment per event
+func (notifier *MattermostNotifier) Notify(report *Report) error {
+	message := &MattermostMessage{
+		Username: "Slack Roll Call",
+		Text:     "#### " + report.Title,
+	}
+
+	for _, event := range report.Events {
+		attachment := &MattermostAttachment{
+			Fallback: event.Text,
+			Color:    fmt.Sprintf("#%06X", eventColor(event)),
+			Title:    eventTitle(event),
+		}
+		for _, field := range eventFields(event) {
+			attachment.Fields = append(attachment.Fields, &MattermostField{field.Name, field.Value, true})
+		}
+		message.Attachments = append(message.Attachments, attachment)
+	}
+
+	_, err := postJSON(notifier.URL, message)
+	return err
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMattermostNotifier(t *testing.T) {
+	url, payloads := recordPayloads(t)
+
+	notifier := &MattermostNotifier{url}
+	if err := notifier.Notify(&Report{Title: "Roll Call", Events: testEvents()}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*payloads) != 1 {
+		t.Fatalf("%d messages posted, want one", len(*payloads))
+	}
+
+	message := &MattermostMessage{}
+	if err := json.Unmarshal((*payloads)[0], message); err != nil {
+		t.Fatal(err)
+	}
+	if message.Text != "#### Roll Call" || len(message.Attachments) != 4 {
+		t.Fatalf("got %q with %d attachments", message.Text, len(message.Attachments))
+	}
+
+	tests := []struct {
+		title    string
+		color    string
+		fallback string
+		field    string
+		value    string
+	}{
+		{"New Member", "#2EB67D", "+++ New Member, ann", "Title", "Engineer"},
+		{"Missing Member", "#E01E5A", "--- Missing, bob", "Email", "bob@example.com"},
+		{"Role Changed", "#ECB22E", "*** Role Changed, ann", "Role", "Member -> Admin"},
+		{"Added Channel", "#2EB67D", "+++ Added Channel, general", "Channel", "#general"},
+	}
+
+	for i, test := range tests {
+		attachment := message.Attachments[i]
+		if attachment.Title != test.title || attachment.Color != test.color || attachment.Fallback != test.fallback {
+			t.Errorf("attachment %d: got %q %s %q, want %q %s %q", i,
+				attachment.Title, attachment.Color, attachment.Fallback, test.title, test.color, test.fallback)
+		}
+
+		found := false
+		for _, field := range attachment.Fields {
+			if field.Title == test.field && field.Value == test.value && field.Short {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: no short %s field of %q", test.title, test.field, test.value)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yepher/SlackRollCall/conversations"
+)
+
+/**
+Prometheus metrics: https://prometheus.io/docs/instrumenting/exposition_formats/
+	Served on /metrics of --serve. Member and channel counts are read from
+	the caches when scraped; event, fetch and failure counts cover the time
+	since start up.
+**/
+
+// metricsHistogram counts observations into cumulative buckets
+type metricsHistogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+var metricsLock sync.Mutex
+var eventCounts = map[string]uint64{}
+var fetchFailures = map[string]uint64{}
+var notificationFailures = map[string]uint64{}
+var memberFetchSeconds = newMetricsHistogram(0.5, 1, 2, 5, 10, 30, 60, 120, 300)
+var memberFetchPages = newMetricsHistogram(1, 2, 5, 10, 20, 50, 100, 200)
+
+func newMetricsHistogram(buckets ...float64) *metricsHistogram {
+	return &metricsHistogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (histogram *metricsHistogram) observe(value float64) {
+	for i, bucket := range histogram.buckets {
+		if value <= bucket {
+			histogram.counts[i]++
+		}
+	}
+	histogram.sum += value
+	histogram.count++
+}
+
+// recordEvents counts the reported events by type
+func recordEvents(events []*Event) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+
+	for _, event := range events {
+		eventCounts[event.Type]++
+	}
+}
+
+// recordMemberFetch records how long users.list took and how many pages it returned
+func recordMemberFetch(duration time.Duration, pages int, err error) {
+	if err != nil {
+		recordFetchFailure("users")
+		return
+	}
+
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+
+	memberFetchSeconds.observe(duration.Seconds())
+	memberFetchPages.observe(float64(pages))
+}
+
+// recordFetchFailure counts a failed users.list or conversations.list
+func recordFetchFailure(list string) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+
+	fetchFailures[list]++
+}
+
+// recordNotificationFailure counts a report a notifier could not deliver
+func recordNotificationFailure(notifier string) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+
+	notificationFailures[notifier]++
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	if members := loadMembersFromFile(memberCacheFile); members != nil {
+		counts := map[string]int{"total": 0, "active": 0, "deleted": 0, "bot": 0, "guest": 0, "admin": 0}
+		for _, member := range members.Members {
+			counts["total"]++
+			if member.Deleted {
+				counts["deleted"]++
+			} else {
+				counts["active"]++
+			}
+			if member.IsBot {
+				counts["bot"]++
+			}
+			if member.IsRestricted || member.IsUltraRestricted {
+				counts["guest"]++
+			}
+			if member.IsAdmin || member.IsOwner {
+				counts["admin"]++
+			}
+		}
+		writeMetricGauges(w, "slackrollcall_members", "Members in the member cache", "state", counts)
+	}
+
+	if channelCacheFile != "" {
+		if channels := conversations.LoadFromFile(channelCacheFile); channels != nil {
+			counts := map[string]int{"public": 0, "private": 0, "archived": 0}
+			for _, channel := range channels.Channels {
+				if channel.IsArchived {
+					counts["archived"]++
+				} else if channel.IsPrivate {
+					counts["private"]++
+				} else {
+					counts["public"]++
+				}
+			}
+			writeMetricGauges(w, "slackrollcall_channels", "Channels in the channel cache", "state", counts)
+		}
+	}
+
+	healthLock.Lock()
+	lastSuccesses := map[string]int{}
+	for name, status := range health.Checks {
+		if status.LastSuccess != nil {
+			lastSuccesses[name] = int(status.LastSuccess.Unix())
+		}
+	}
+	healthLock.Unlock()
+	writeMetricGauges(w, "slackrollcall_last_success_timestamp_seconds", "Time of the last successful check run", "check", lastSuccesses)
+
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+
+	writeMetricCounters(w, "slackrollcall_events_total", "Change events reported", "type", eventCounts)
+	writeMetricCounters(w, "slackrollcall_fetch_failures_total", "Failed Slack list fetches", "list", fetchFailures)
+	writeMetricCounters(w, "slackrollcall_notification_failures_total", "Reports a notifier failed to deliver", "notifier", notificationFailures)
+	writeMetricHistogram(w, "slackrollcall_users_list_duration_seconds", "Time taken to load users.list", memberFetchSeconds)
+	writeMetricHistogram(w, "slackrollcall_users_list_pages", "Pages returned by users.list", memberFetchPages)
+}
+
+func writeMetricGauges(w io.Writer, name string, help string, label string, values map[string]int) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, key, values[key])
+	}
+}
+
+func writeMetricCounters(w io.Writer, name string, help string, label string, values map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, key, values[key])
+	}
+}
+
+func writeMetricHistogram(w io.Writer, name string, help string, histogram *metricsHistogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, bucket := range histogram.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(bucket, 'g', -1, 64), histogram.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, histogram.count)
+	fmt.Fprintf(w, "%s_sum %g\n", name, histogram.sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, histogram.count)
+}
+
+func sortedKeys(values map[string]int) []string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
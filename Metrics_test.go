@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yepher/SlackRollCall/conversations"
+)
+
+func TestServeMetrics(t *testing.T) {
+	dir := t.TempDir()
+	memberCacheFile = filepath.Join(dir, "userList.cache")
+	channelCacheFile = filepath.Join(dir, "channelList.cache")
+	t.Cleanup(func() { memberCacheFile, channelCacheFile = "", "" })
+
+	eventCounts = map[string]uint64{}
+	fetchFailures = map[string]uint64{}
+	notificationFailures = map[string]uint64{}
+	memberFetchSeconds = newMetricsHistogram(0.5, 1, 2, 5, 10, 30, 60, 120, 300)
+	memberFetchPages = newMetricsHistogram(1, 2, 5, 10, 20, 50, 100, 200)
+
+	writeTestCache(t, memberCacheFile, &MemberList{Ok: true, Members: []*User{
+		{ID: "U1", IsBot: true},
+		{ID: "U2", Deleted: true, IsRestricted: true},
+		{ID: "U3", IsOwner: true},
+	}})
+	writeTestCache(t, channelCacheFile, &conversations.List{Ok: true, Channels: []*conversations.Channel{
+		{ID: "C1", Name: "general"},
+		{ID: "C2", Name: "random"},
+		{ID: "C3", Name: "security", IsPrivate: true},
+		{ID: "C4", Name: "old-project", IsArchived: true},
+		{ID: "C5", Name: "old-secret", IsPrivate: true, IsArchived: true},
+	}})
+
+	recordEvents([]*Event{{Type: EventMemberJoined}, {Type: EventMemberJoined}})
+	recordMemberFetch(3*time.Second, 4, nil)
+	recordMemberFetch(time.Second, 0, errors.New("ratelimited"))
+
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+	notifyAll([]Notifier{&WebhookNotifier{URLs: []string{rejecting.URL}, DeadLetter: filepath.Join(dir, "webhook.deadletter")}}, &Report{})
+
+	response := httptest.NewRecorder()
+	serveMetrics(response, httptest.NewRequest("GET", "/metrics", nil))
+	out := response.Body.String()
+
+	for _, want := range []string{
+		`slackrollcall_members{state="total"} 3`,
+		`slackrollcall_members{state="active"} 2`,
+		`slackrollcall_members{state="guest"} 1`,
+		`slackrollcall_members{state="admin"} 1`,
+		`slackrollcall_channels{state="archived"} 2`,
+		`slackrollcall_channels{state="private"} 1`,
+		`slackrollcall_channels{state="public"} 2`,
+		`slackrollcall_events_total{type="member_joined"} 2`,
+		`slackrollcall_fetch_failures_total{list="users"} 1`,
+		`slackrollcall_notification_failures_total{notifier="Webhook"} 1`,
+		`slackrollcall_users_list_duration_seconds_bucket{le="2"} 0`,
+		`slackrollcall_users_list_duration_seconds_bucket{le="5"} 1`,
+		`slackrollcall_users_list_pages_sum 4`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
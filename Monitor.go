@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/**
+Monitor rules select the new members reported as suspect.
+
+	Each entry of --monitor is one rule:
+
+		gmail.com           email domain is exactly gmail.com
+		*.example.com       email domain is a subdomain of example.com
+		gmail               anywhere in the email, as in older versions
+		/^admin[0-9]*@/     regular expression matched against the email
+
+	Prefix a rule with name:, realname: or title: to match that field
+	instead of the email. Plain text then matches anywhere in the field,
+	ignoring case, and /.../ is a regular expression.
+**/
+
+// Fields a monitor rule can match on
+const (
+	MonitorEmail    = "email"
+	MonitorName     = "name"
+	MonitorRealName = "realname"
+	MonitorTitle    = "title"
+)
+
+// MonitorRule is a single parsed --monitor entry
+type MonitorRule struct {
+	Rule    string
+	Field   string
+	Pattern string
+
+	regex     *regexp.Regexp
+	substring bool
+}
+
+func parseMonitorRules(rules []string) ([]*MonitorRule, error) {
+	parsed := []*MonitorRule{}
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		monitorRule, err := parseMonitorRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, monitorRule)
+	}
+
+	return parsed, nil
+}
+
+func parseMonitorRule(rule string) (*MonitorRule, error) {
+	monitorRule := &MonitorRule{Rule: rule, Field: MonitorEmail, Pattern: rule}
+
+	for _, field := range []string{MonitorEmail, MonitorName, MonitorRealName, MonitorTitle} {
+		if strings.HasPrefix(strings.ToLower(rule), field+":") {
+			monitorRule.Field = field
+			monitorRule.Pattern = rule[len(field)+1:]
+			break
+		}
+	}
+
+	pattern := monitorRule.Pattern
+	if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		regex, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("monitor rule %q: %v", rule, err)
+		}
+		monitorRule.regex = regex
+		return monitorRule, nil
+	}
+
+	if monitorRule.Field == MonitorEmail {
+		// Without a dot the rule is no domain, match it anywhere in the email
+		// as older versions did
+		if !strings.Contains(pattern, ".") {
+			monitorRule.substring = true
+			return monitorRule, nil
+		}
+
+		// Older versions matched "@gmail.com" anywhere in the email
+		monitorRule.Pattern = strings.ToLower(strings.TrimPrefix(pattern, "@"))
+		if strings.Contains(strings.TrimPrefix(monitorRule.Pattern, "*."), "*") {
+			return nil, fmt.Errorf("monitor rule %q: only a leading *. wildcard is supported", rule)
+		}
+	}
+
+	return monitorRule, nil
+}
+
+func (rule *MonitorRule) matches(user *User) bool {
+	if rule.Field == MonitorEmail {
+		if rule.regex != nil {
+			return rule.regex.MatchString(user.Profile.Email)
+		}
+		if rule.substring {
+			return caseInsensitiveContains(user.Profile.Email, rule.Pattern)
+		}
+		return domainMatches(emailDomain(user.Profile.Email), rule.Pattern)
+	}
+
+	for _, value := range rule.fieldValues(user) {
+		if value == "" {
+			continue
+		}
+
+		if rule.regex != nil {
+			if rule.regex.MatchString(value) {
+				return true
+			}
+		} else if caseInsensitiveContains(value, rule.Pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (rule *MonitorRule) fieldValues(user *User) []string {
+	switch rule.Field {
+	case MonitorName:
+		return []string{user.Name}
+	case MonitorRealName:
+		return []string{user.RealName, user.Profile.RealName, user.Profile.RealNameNormalized}
+	case MonitorTitle:
+		return []string{user.Profile.Title}
+	}
+	return []string{}
+}
+
+// emailDomain returns the lower case domain of an email address, or an
+// empty string when the address has no domain
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(email[at+1:])), ".")
+}
+
+// domainMatches reports whether domain is pattern, or a subdomain of it when
+// pattern starts with "*."
+func domainMatches(domain string, pattern string) bool {
+	if domain == "" {
+		return false
+	}
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(domain, pattern[1:])
+	}
+
+	return domain == pattern
+}
@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestMonitorRules(t *testing.T) {
+	tests := []struct {
+		rule     string
+		email    string
+		title    string
+		realName string
+		matches  bool
+	}{
+		{"gmail.com", "ann@gmail.com", "", "", true},
+		{"gmail.com", "ann@GMAIL.com", "", "", true},
+		{"@gmail.com", "ann@gmail.com", "", "", true},
+		{"gmail.com", "ann@notgmail.company.io", "", "", false},
+		{"gmail.com", "ann@mail.gmail.com", "", "", false},
+		{"*.evil.org", "ann@x.evil.org", "", "", true},
+		{"*.evil.org", "ann@evil.org", "", "", false},
+		// Rules without a dot match anywhere in the email, as they used to
+		{"gmail", "ann@gmail.com", "", "", true},
+		{"gmail", "ann@notgmail.company.io", "", "", true},
+		{"GMAIL", "ann@gmail.com", "", "", true},
+		{"@gmail", "ann@gmail.com", "", "", true},
+		{"@gmail", "gmailfan@example.com", "", "", false},
+		{"admin", "sysadmin@example.com", "", "", true},
+		{"gmail", "ann@example.com", "", "", false},
+		{"/^admin[0-9]*@/", "admin3@example.com", "", "", true},
+		{"/^admin[0-9]*@/", "sysadmin@example.com", "", "", false},
+		{"title:CEO", "ann@example.com", "the ceo", "", true},
+		{"realname:/(?i)^bob /", "bob@example.com", "", "Bob Smith", true},
+		{"realname:/(?i)^bob /", "bob@example.com", "", "Bobby", false},
+	}
+
+	for _, test := range tests {
+		rule, err := parseMonitorRule(test.rule)
+		if err != nil {
+			t.Errorf("%s: %v", test.rule, err)
+			continue
+		}
+
+		user := testUser("U1", "ann", test.email)
+		user.RealName = test.realName
+		user.Profile.Title = test.title
+		if matches := rule.matches(user); matches != test.matches {
+			t.Errorf("%s against %s: got %v, want %v", test.rule, test.email, matches, test.matches)
+		}
+	}
+}
+
+func TestMonitorRulesReject(t *testing.T) {
+	for _, rule := range []string{"/[/", "mail.*.com", "*gmail.com"} {
+		if _, err := parseMonitorRule(rule); err == nil {
+			t.Errorf("%q was accepted", rule)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yepher/SlackRollCall/conversations"
+)
+
+/**
+Notifiers deliver the result of a roll call run.
+
+	Every destination given on the command line or in a route becomes a
+	Notifier. Text based notifiers (Slack, email) send Report.Text, the chat
+	adapters (Teams, Discord, Mattermost) format Report.Events natively.
+**/
+
+// Report is the result of a roll call run handed to every notifier
+type Report struct {
+	Title  string
+	Text   string
+	Events []*Event
+}
+
+// Notifier delivers a Report to an external system
+type Notifier interface {
+	Name() string
+	Notify(report *Report) error
+}
+
+// EventField is a label and value describing part of an event
+type EventField struct {
+	Name  string
+	Value string
+}
+
+var notifiers = []Notifier{}
+
+// eventFieldMaxLength keeps field values within the smallest limit of the
+// chat adapters, Discord's 1024 characters
+const eventFieldMaxLength = 1024
+
+var eventTitles = map[string]string{
+	EventMemberJoined:      "New Member",
+	EventMemberMissing:     "Missing Member",
+	EventMemberDeleted:     "Member Deactivated",
+	EventMemberRestored:    "Member Reactivated",
+	EventSuspectMember:     "Suspect Member",
+	EventRoleChanged:       "Role Changed",
+	EventEmailChanged:      "Email Changed",
+	EventMemberRaid:        "Raid",
+	EventRaidOver:          "Raid Over",
+	EventChannelCreated:    "Added Channel",
+	EventChannelRemoved:    "Removed Channel",
+	EventChannelArchived:   "Channel Archived",
+	EventChannelUnarchived: "Channel Unarchived",
+	EventChannelRenamed:    "Channel Renamed",
+}
+
+func notifyAll(notifiers []Notifier, report *Report) {
+	for _, notifier := range notifiers {
+		err := notifier.Notify(report)
+		if err != nil {
+			slog.Error("notification failed", "notifier", notifier.Name(), "error", err)
+			recordNotificationFailure(notifier.Name())
+		}
+	}
+}
+
+func eventTitle(event *Event) string {
+	if title, ok := eventTitles[event.Type]; ok {
+		return title
+	}
+	return event.Type
+}
+
+// eventColor returns an RGB color for the event: green for additions, red
+// for removals and orange for anything that needs a closer look
+func eventColor(event *Event) int {
+	switch event.Type {
+	case EventMemberJoined, EventMemberRestored, EventChannelCreated, EventChannelUnarchived:
+		return 0x2EB67D
+	case EventMemberMissing, EventMemberDeleted, EventChannelRemoved, EventChannelArchived:
+		return 0xE01E5A
+	default:
+		return 0xECB22E
+	}
+}
+
+func eventFields(event *Event) []EventField {
+	fields := []EventField{}
+
+	user := event.User
+	if user == nil {
+		user = event.Previous
+	}
+
+	if user != nil {
+		fields = append(fields, EventField{"Name", displayName(user)})
+		if user.Profile.Email != "" {
+			fields = append(fields, EventField{"Email", user.Profile.Email})
+		}
+		if user.Profile.Title != "" {
+			fields = append(fields, EventField{"Title", user.Profile.Title})
+		}
+		if user.IsBot {
+			fields = append(fields, EventField{"Bot", user.Profile.BotId})
+		}
+		if event.Type == EventRoleChanged && event.Previous != nil {
+			fields = append(fields, EventField{"Role", memberRole(event.Previous) + " -> " + memberRole(user)})
+		}
+		if event.Type == EventEmailChanged && event.Previous != nil {
+			fields = append(fields, EventField{"Previous Email", event.Previous.Profile.Email})
+		}
+		if len(event.Reasons) > 0 {
+			fields = append(fields, EventField{"Reasons", strings.Join(event.Reasons, ", ")})
+		}
+	}
+
+	if len(event.Users) > 0 {
+		names := []string{}
+		for _, member := range event.Users {
+			names = append(names, displayName(member))
+		}
+		fields = append(fields, EventField{"Members", joinLimited(names, eventFieldMaxLength)})
+		fields = append(fields, EventField{"Reasons", strings.Join(event.Reasons, ", ")})
+	}
+
+	if event.Channel != nil {
+		fields = append(fields, EventField{"Channel", "#" + event.Channel.Name})
+		description := strings.Trim(conversations.Description(event.Channel), "`")
+		if description != "" {
+			fields = append(fields, EventField{"Purpose", description})
+		}
+	}
+
+	return fields
+}
+
+// joinLimited joins the names with commas, ending with "+N more" rather than
+// going over max bytes
+func joinLimited(names []string, max int) string {
+	if all := strings.Join(names, ", "); len(all) <= max {
+		return all
+	}
+
+	joined := ""
+	for i, name := range names {
+		next := name
+		if i > 0 {
+			next = joined + ", " + name
+		}
+
+		more := ""
+		if remaining := len(names) - i - 1; remaining > 0 {
+			more = fmt.Sprintf(", +%d more", remaining)
+		}
+
+		if len(next)+len(more) > max {
+			if joined == "" {
+				return fmt.Sprintf("+%d more", len(names))
+			}
+			return fmt.Sprintf("%s, +%d more", joined, len(names)-i)
+		}
+		joined = next
+	}
+	return joined
+}
+
+// displayName builds a reliable name for a user
+func displayName(user *User) string {
+	if len(user.RealName) > 0 {
+		return user.RealName
+	} else if len(user.Profile.RealName) > 0 {
+		return user.Profile.RealName
+	} else if len(user.Name) > 0 {
+		return user.Name
+	}
+	return user.ID
+}
+
+func postJSON(url string, payload interface{}) ([]byte, error) {
+	json, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		showDryRun("would post to "+dryRunHost(url), json)
+		return nil, nil
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Post(url, "application/json; charset=utf-8", bytes.NewBuffer(json))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	contents, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return contents, fmt.Errorf("%s: %s", response.Status, strings.TrimSpace(string(contents)))
+	}
+
+	return contents, nil
+}
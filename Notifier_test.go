@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yepher/SlackRollCall/conversations"
+)
+
+// recordPayloads serves a webhook for the rest of the test, keeping every
+// body posted to it
+func recordPayloads(t *testing.T) (string, *[][]byte) {
+	payloads := [][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		payloads = append(payloads, body)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, &payloads
+}
+
+// testEvents is a report's worth of events of every kind of subject
+func testEvents() []*Event {
+	ann := testUser("U1", "ann", "ann@example.com")
+	ann.Profile.Title = "Engineer"
+	admin := testUser("U1", "ann", "ann@example.com")
+	admin.IsAdmin = true
+
+	return []*Event{
+		newMemberEvent(EventMemberJoined, ann, nil, "+++ New Member, ann"),
+		newMemberEvent(EventMemberMissing, testUser("U2", "bob", "bob@example.com"), nil, "--- Missing, bob"),
+		newMemberEvent(EventRoleChanged, admin, ann, "*** Role Changed, ann"),
+		newChannelEvent(EventChannelCreated, &conversations.Channel{ID: "C1", Name: "general"}, "+++ Added Channel, general"),
+	}
+}
+
+func TestJoinLimited(t *testing.T) {
+	tests := []struct {
+		names []string
+		max   int
+		want  string
+	}{
+		{[]string{}, 10, ""},
+		{[]string{"aaaa", "bbbb"}, 10, "aaaa, bbbb"},
+		{[]string{"aaaa", "bbbb", "cccc", "dddd", "eeee"}, 20, "aaaa, bbbb, +3 more"},
+		{[]string{"aaaa", "bbbb", "cccc", "dddd", "eeee"}, 25, "aaaa, bbbb, cccc, +2 more"},
+		{[]string{"a very long name"}, 8, "+1 more"},
+	}
+
+	for _, test := range tests {
+		got := joinLimited(test.names, test.max)
+		if got != test.want {
+			t.Errorf("joinLimited(%q, %d) = %q, want %q", test.names, test.max, got, test.want)
+		}
+		if len(got) > test.max && len(test.names) > 0 {
+			t.Errorf("joinLimited(%q, %d) is %d long", test.names, test.max, len(got))
+		}
+	}
+}
+
+func TestDiscordRaidFieldsFit(t *testing.T) {
+	var posted []*DiscordMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		message := &DiscordMessage{}
+		json.NewDecoder(r.Body).Decode(message)
+		posted = append(posted, message)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	raiders := []*User{}
+	for i := 0; i < 300; i++ {
+		raiders = append(raiders, testUser(fmt.Sprintf("U%d", i), fmt.Sprintf("spam account %d", i), ""))
+	}
+	raid := &Event{Type: EventMemberRaid, Users: raiders, Reasons: []string{"threshold is 10"}, Text: "!!! Raid"}
+
+	notifier := &DiscordNotifier{URL: server.URL}
+	if err := notifier.Notify(&Report{Title: "Raid", Events: []*Event{raid}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(posted) != 1 {
+		t.Fatalf("%d messages posted", len(posted))
+	}
+	for _, field := range posted[0].Embeds[0].Fields {
+		if len(field.Value) > 1024 {
+			t.Errorf("%s is %d characters, over Discord's limit", field.Name, len(field.Value))
+		}
+	}
+}
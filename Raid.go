@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+/**
+Join-burst (raid) detection across runs.
+
+	Every member seen joining is recorded in the --history file, one JSON
+	record per line. When at least --raidthreshold members joined within the
+	last --raidwindow, or with --raidfactor set, more than that many times
+	the usual number of joins per window, the run reports a single raid
+	event listing the accounts instead of a line per new member.
+
+	A full run only knows a member joined since the member cache was
+	written, so its joins are counted as spread evenly over that interval: a
+	daily run finding 40 joins counts well under one join per 15 minutes.
+	Joins received as Slack events are counted at the time they arrive.
+
+	Once a raid is reported it stays open until a --raidwindow passes
+	without joins. Members joining an open raid are not reported one by one,
+	they are listed once in a raid over event when it ends. The open raid is
+	kept as a line of the history file, so runs from cron know of it too.
+**/
+
+var historyFile = ""
+var raidWindow = 15 * time.Minute
+var raidThreshold = 10
+var raidFactor = 0.0
+
+// raidHistoryAge is how long join records are kept for the baseline
+const raidHistoryAge = 30 * 24 * time.Hour
+
+// raidMinimumJoins keeps a quiet workspace's baseline from flagging a couple of joins
+const raidMinimumJoins = 3
+
+// JoinRecord is a line of the history file. Since is the start of the
+// interval the member joined in, the previous run for a full run.
+type JoinRecord struct {
+	Time  time.Time `json:"time"`
+	Since time.Time `json:"since,omitempty"`
+	ID    string    `json:"id"`
+	Name  string    `json:"name"`
+	Email string    `json:"email,omitempty"`
+}
+
+// Raid is a reported raid that is still open, kept as the last line of the
+// history file
+type Raid struct {
+	Until time.Time `json:"raid_until"`
+	Users []*User   `json:"raid_users"`
+}
+
+var openRaid *Raid
+
+// detectRaid records the joins seen since the given time in the history file
+// and, when they are part of a raid, replaces their events with a single
+// raid event
+func detectRaid(events []*Event, since time.Time) []*Event {
+	records, raid, err := loadJoinHistory(historyFile)
+	if err != nil {
+		slog.Error("join history", "file", historyFile, "error", err)
+		return events
+	}
+	openRaid = raid
+
+	now := time.Now().UTC()
+	if since.IsZero() || since.After(now) {
+		since = now
+	}
+
+	known := map[string]bool{}
+	for _, record := range records {
+		known[record.ID] = true
+	}
+
+	joined := []*User{}
+	for _, event := range events {
+		if event.Type == EventMemberJoined && !known[event.User.ID] {
+			known[event.User.ID] = true
+			joined = append(joined, event.User)
+			records = append(records, &JoinRecord{now, since.UTC(), event.User.ID, displayName(event.User), event.User.Profile.Email})
+		}
+	}
+
+	kept := []*JoinRecord{}
+	for _, record := range records {
+		if now.Sub(record.Time) <= raidHistoryAge {
+			kept = append(kept, record)
+		}
+	}
+
+	defer func() {
+		if err := writeJoinHistory(historyFile, kept, openRaid); err != nil {
+			slog.Error("join history", "file", historyFile, "error", err)
+		}
+	}()
+
+	result := closeRaid(now)
+
+	if openRaid != nil && len(joined) > 0 {
+		openRaid.Users = append(openRaid.Users, joined...)
+		openRaid.Until = now.Add(raidWindow)
+		slog.Info("raid continues", "joined", len(joined), "members", len(openRaid.Users))
+		return append(result, withoutRaiders(events, joined)...)
+	}
+
+	windowStart := now.Add(-raidWindow)
+	recent := joinsBetween(kept, windowStart, now)
+	reason := raidReason(kept, recent, now)
+	if reason == "" || len(joined) == 0 {
+		return append(result, events...)
+	}
+
+	accounts := []string{}
+	for _, record := range kept {
+		if record.Time.After(windowStart) || record.Time.Equal(windowStart) {
+			account := record.Name
+			if record.Email != "" {
+				account = fmt.Sprintf("%s (%s)", record.Name, record.Email)
+			}
+			accounts = append(accounts, account)
+		}
+	}
+
+	count := fmt.Sprintf("%d members joined in the last %s", len(accounts), raidWindow)
+	if interval := now.Sub(since); interval > raidWindow {
+		count = fmt.Sprintf("%d members joined in the last %s, about %.0f per %s", len(joined), interval.Round(time.Minute), recent, raidWindow)
+	}
+
+	text := fmt.Sprintf("!!! Raid, %s, %s: %s", count, reason, strings.Join(accounts, ", "))
+	event := &Event{
+		Type:     EventMemberRaid,
+		Time:     now,
+		Users:    joined,
+		Text:     text,
+		Reasons:  []string{reason},
+		Severity: "high",
+	}
+
+	openRaid = &Raid{Until: now.Add(raidWindow), Users: []*User{}}
+
+	result = append(result, event)
+	return append(result, withoutRaiders(events, joined)...)
+}
+
+// closeRaid ends the open raid once a window passed without joins and
+// reports the members that joined after it was reported
+func closeRaid(now time.Time) []*Event {
+	if openRaid == nil || now.Before(openRaid.Until) {
+		return []*Event{}
+	}
+
+	raid := openRaid
+	openRaid = nil
+	if len(raid.Users) == 0 {
+		return []*Event{}
+	}
+
+	names := []string{}
+	for _, user := range raid.Users {
+		names = append(names, displayName(user))
+	}
+
+	return []*Event{{
+		Type:     EventRaidOver,
+		Time:     now,
+		Users:    raid.Users,
+		Text:     fmt.Sprintf("Raid over, %d more members joined after it was reported: %s", len(raid.Users), strings.Join(names, ", ")),
+		Reasons:  []string{"raid over"},
+		Severity: "medium",
+	}}
+}
+
+// withoutRaiders drops the join and suspect events of the raiders, keeping
+// critical suspects, such as impersonators, visible on their own
+func withoutRaiders(events []*Event, raiders []*User) []*Event {
+	ids := map[string]bool{}
+	for _, user := range raiders {
+		ids[user.ID] = true
+	}
+
+	kept := []*Event{}
+	for _, event := range events {
+		if event.User != nil && ids[event.User.ID] && event.Severity != "critical" &&
+			(event.Type == EventMemberJoined || event.Type == EventSuspectMember) {
+			continue
+		}
+		kept = append(kept, event)
+	}
+	return kept
+}
+
+// joinsBetween estimates how many of the recorded members joined between
+// start and end, counting each join as spread evenly over its interval
+func joinsBetween(records []*JoinRecord, start time.Time, end time.Time) float64 {
+	count := 0.0
+	for _, record := range records {
+		from := record.Since
+		if from.IsZero() || from.After(record.Time) {
+			from = record.Time
+		}
+
+		if !from.Before(record.Time) {
+			if !record.Time.Before(start) && !record.Time.After(end) {
+				count++
+			}
+			continue
+		}
+
+		overlapStart, overlapEnd := from, record.Time
+		if overlapStart.Before(start) {
+			overlapStart = start
+		}
+		if overlapEnd.After(end) {
+			overlapEnd = end
+		}
+		if overlapEnd.After(overlapStart) {
+			count += float64(overlapEnd.Sub(overlapStart)) / float64(record.Time.Sub(from))
+		}
+	}
+	return count
+}
+
+// raidReason explains why the number of recent joins is a raid, or returns
+// an empty string when it is not
+func raidReason(records []*JoinRecord, recent float64, now time.Time) string {
+	// Rounded so a run interval that is an exact multiple of the window counts whole joins
+	recent = math.Round(recent*1000) / 1000
+	if recent >= float64(raidThreshold) {
+		return fmt.Sprintf("threshold is %d", raidThreshold)
+	}
+
+	if raidFactor <= 0 || recent < raidMinimumJoins {
+		return ""
+	}
+
+	// The baseline is the average number of joins per window before this one
+	windowStart := now.Add(-raidWindow)
+	oldest := windowStart
+	for _, record := range records {
+		from := record.Since
+		if from.IsZero() {
+			from = record.Time
+		}
+		if from.Before(oldest) {
+			oldest = from
+		}
+	}
+
+	windows := float64(windowStart.Sub(oldest)) / float64(raidWindow)
+	if windows < 1 {
+		return ""
+	}
+
+	baseline := joinsBetween(records, oldest, windowStart) / windows
+	if recent > raidFactor*baseline {
+		return fmt.Sprintf("usually %.1f", baseline)
+	}
+
+	return ""
+}
+
+// loadJoinHistory reads the join records and the open raid, if any, from the
+// history file
+func loadJoinHistory(file string) ([]*JoinRecord, *Raid, error) {
+	records := []*JoinRecord{}
+
+	handle, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return records, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer handle.Close()
+
+	var raid *Raid
+	scanner := bufio.NewScanner(handle)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		// The open raid is the only line with a raid_until
+		open := &Raid{}
+		if err = json.Unmarshal([]byte(line), open); err == nil && !open.Until.IsZero() {
+			raid = open
+			continue
+		}
+
+		record := &JoinRecord{}
+		if err = json.Unmarshal([]byte(line), record); err != nil {
+			return nil, nil, fmt.Errorf("invalid history record in %s: %v", file, err)
+		}
+		records = append(records, record)
+	}
+
+	return records, raid, scanner.Err()
+}
+
+func writeJoinHistory(file string, records []*JoinRecord, raid *Raid) error {
+	if dryRun {
+		showDryRun(fmt.Sprintf("would write %s (%d joins)", file, len(records)), nil)
+		return nil
+	}
+
+	handle, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	encoder := json.NewEncoder(handle)
+	for _, record := range records {
+		if err = encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	if raid != nil {
+		return encoder.Encode(raid)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func resetRaid(t *testing.T, threshold int, window time.Duration) {
+	historyFile = filepath.Join(t.TempDir(), "history.jsonl")
+	raidThreshold, raidWindow, raidFactor, openRaid = threshold, window, 0, nil
+	t.Cleanup(func() {
+		historyFile = ""
+		raidThreshold, raidWindow, raidFactor, openRaid = 10, 15*time.Minute, 0, nil
+	})
+}
+
+// joinEvents returns the joined and suspect events of new members
+func joinEvents(ids ...string) []*Event {
+	events := []*Event{}
+	for _, id := range ids {
+		user := testUser(id, id, id+"@example.com")
+		events = append(events,
+			newMemberEvent(EventMemberJoined, user, nil, "+++ New Member, "+id),
+			newMemberEvent(EventSuspectMember, user, nil, "*** "+id))
+	}
+	return events
+}
+
+func countType(events []*Event, eventType string) int {
+	count := 0
+	for _, event := range events {
+		if event.Type == eventType {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRaidFromRealTimeJoins(t *testing.T) {
+	resetRaid(t, 3, 15*time.Minute)
+
+	events := detectRaid(joinEvents("U1"), time.Now())
+	if countType(events, EventMemberRaid) != 0 || len(events) != 2 {
+		t.Fatalf("one join reported as a raid: %#v", events)
+	}
+
+	events = detectRaid(joinEvents("U2", "U3"), time.Now())
+	if len(events) != 1 || events[0].Type != EventMemberRaid {
+		t.Fatalf("got %d events, want the raid alone", len(events))
+	}
+	if len(events[0].Users) != 2 || !strings.Contains(events[0].Text, "3 members joined") {
+		t.Fatalf("raid text %q with %d users", events[0].Text, len(events[0].Users))
+	}
+
+	records, raid, _ := loadJoinHistory(historyFile)
+	if len(records) != 3 || raid == nil {
+		t.Fatalf("%d history records, open raid %v, want 3 and the raid", len(records), raid)
+	}
+}
+
+func TestRaidSpreadsJoinsOverRunInterval(t *testing.T) {
+	tests := []struct {
+		name      string
+		interval  time.Duration
+		joins     int
+		window    time.Duration
+		threshold int
+		raid      bool
+	}{
+		{"daily run of ordinary joins", 24 * time.Hour, 8, 10 * time.Minute, 8, false},
+		{"daily run of a large burst", 24 * time.Hour, 2000, 10 * time.Minute, 8, true},
+		{"run within the window", 10 * time.Minute, 8, 10 * time.Minute, 8, true},
+		{"hourly run at the threshold rate", time.Hour, 60, 10 * time.Minute, 10, true},
+		{"hourly run under the threshold rate", time.Hour, 50, 10 * time.Minute, 10, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetRaid(t, test.threshold, test.window)
+
+			ids := []string{}
+			for i := 0; i < test.joins; i++ {
+				ids = append(ids, fmt.Sprintf("U%d", i))
+			}
+
+			events := detectRaid(joinEvents(ids...), time.Now().Add(-test.interval))
+			if raid := countType(events, EventMemberRaid) == 1; raid != test.raid {
+				t.Fatalf("raid %v, want %v", raid, test.raid)
+			}
+		})
+	}
+}
+
+func TestRaidStaysOpen(t *testing.T) {
+	resetRaid(t, 3, 15*time.Minute)
+
+	events := detectRaid(joinEvents("U1", "U2", "U3"), time.Now())
+	if countType(events, EventMemberRaid) != 1 {
+		t.Fatal("raid not reported")
+	}
+
+	// Later joins to the open raid are neither alerted again nor reported one
+	// by one, also by a later run that only knows of the raid from the history
+	for i := 4; i <= 8; i++ {
+		openRaid = nil
+		events = detectRaid(joinEvents(fmt.Sprintf("U%d", i)), time.Now())
+		if len(events) != 0 {
+			t.Fatalf("join %d reported while the raid is open: %#v", i, events)
+		}
+	}
+
+	// Once a window passes without joins the late joins are listed once
+	records, raid, _ := loadJoinHistory(historyFile)
+	raid.Until = time.Now().Add(-time.Second)
+	if err := writeJoinHistory(historyFile, records, raid); err != nil {
+		t.Fatal(err)
+	}
+	events = detectRaid([]*Event{}, time.Now())
+	if len(events) != 1 || events[0].Type != EventRaidOver || len(events[0].Users) != 5 {
+		t.Fatalf("got %#v, want the end of the raid with 5 members", events)
+	}
+	if events[0].Severity == "high" || !strings.Contains(events[0].Text, "Raid over") {
+		t.Fatalf("raid end %q reported as %s", events[0].Text, events[0].Severity)
+	}
+	if _, raid, _ = loadJoinHistory(historyFile); openRaid != nil || raid != nil {
+		t.Fatal("raid still open")
+	}
+
+	// The end of a raid is reported without the warning or a mention
+	escalateTargets = []string{"here"}
+	t.Cleanup(func() { escalateTargets = []string{"everyone"} })
+	report := renderReport(events, false)
+	if !strings.Contains(report, "raid over.") || strings.Contains(report, "WARNING") || strings.Contains(report, "<!here>") {
+		t.Fatalf("raid end reported as a warning:\n%s", report)
+	}
+}
+
+func TestRaidReasonBaseline(t *testing.T) {
+	resetRaid(t, 100, 15*time.Minute)
+	raidFactor = 3
+
+	now := time.Now().UTC()
+	history := []*JoinRecord{}
+	for i := 0; i < 8; i++ {
+		history = append(history, &JoinRecord{Time: now.Add(-time.Duration(i+1) * time.Hour)})
+	}
+
+	tests := []struct {
+		recent float64
+		raid   bool
+	}{
+		{2, false},
+		{4, true},
+		{100, true},
+	}
+	for _, test := range tests {
+		if raid := raidReason(history, test.recent, now) != ""; raid != test.raid {
+			t.Errorf("%.0f recent joins: raid %v, want %v", test.recent, raid, test.raid)
+		}
+	}
+}
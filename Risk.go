@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/**
+Risk scoring for newly joined members.
+
+	Each new member gets a score from 0 to 100 adding up the weight of every
+	suspect reason (monitored, unapproved domain, lookalike, ...) and of the
+	weaker signals below. Members scoring at least --riskthreshold are listed
+	as suspect members even without a suspect reason, and only they and high
+	or critical suspects raise the escalation warning.
+**/
+
+// Weights of the signals found for a new member
+const (
+	riskNoRealName    = 10
+	riskNoTitle       = 5
+	riskDefaultAvatar = 10
+	riskGuest         = 10
+	riskJoinBurst     = 15
+	riskOtherReason   = 20
+)
+
+// riskBurstSize is the number of members joining in one run counted as a burst
+const riskBurstSize = 5
+
+var riskScoring = false
+var riskThreshold = 60
+
+// riskReasonWeights weighs suspect reasons by their prefix
+var riskReasonWeights = []struct {
+	prefix string
+	weight int
+}{
+	{"impersonates", 60},
+	{"lookalike of", 50},
+	{"disposable email", 40},
+	{"monitored", 30},
+	{"unapproved domain", 30},
+	{"free email provider", 10},
+}
+
+// riskScore returns the user's score and the signals it was built from,
+// given the suspect reasons already found and the number of members that
+// joined in this run
+func riskScore(user *User, reasons []string, newMembers int) (int, []string) {
+	score := 0
+	signals := []string{}
+
+	for _, reason := range reasons {
+		weight := riskOtherReason
+		for _, reasonWeight := range riskReasonWeights {
+			if strings.HasPrefix(reason, reasonWeight.prefix) {
+				weight = reasonWeight.weight
+				break
+			}
+		}
+		score += weight
+	}
+
+	if user.RealName == "" && user.Profile.RealName == "" {
+		score += riskNoRealName
+		signals = append(signals, "no real name")
+	}
+
+	if user.Profile.Title == "" {
+		score += riskNoTitle
+		signals = append(signals, "no title")
+	}
+
+	if hasDefaultAvatar(user) {
+		score += riskDefaultAvatar
+		signals = append(signals, "default avatar")
+	}
+
+	if user.IsRestricted || user.IsUltraRestricted {
+		score += riskGuest
+		signals = append(signals, "guest")
+	}
+
+	if newMembers >= riskBurstSize {
+		score += riskJoinBurst
+		signals = append(signals, fmt.Sprintf("joined with %d others", newMembers-1))
+	}
+
+	if score > 100 {
+		score = 100
+	}
+
+	return score, signals
+}
+
+// hasDefaultAvatar reports whether the user kept one of Slack's generated avatars
+func hasDefaultAvatar(user *User) bool {
+	if user.Profile.IsCustomImage {
+		return false
+	}
+
+	image := user.Profile.Image72
+	return image == "" || strings.Contains(image, "/img/avatars/") || strings.Contains(image, "gravatar.com")
+}
+
+// shouldEscalate reports whether the suspect members warrant the escalation
+// warning. High and critical suspects, such as impersonators or those raised
+// by a rule, escalate whatever their score.
+func shouldEscalate(events []*Event) bool {
+	for _, event := range events {
+		if event.Type != EventSuspectMember {
+			continue
+		}
+		if !riskScoring || event.Score >= riskThreshold || severityRank(event.Severity) >= severityRank("high") {
+			return true
+		}
+	}
+	return false
+}
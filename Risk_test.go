@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShouldEscalate(t *testing.T) {
+	suspect := func(score int, severity string) *Event {
+		event := newMemberEvent(EventSuspectMember, testUser("U1", "ann", "ann@example.com"), nil, "")
+		event.Score = score
+		event.Severity = severity
+		return event
+	}
+
+	tests := []struct {
+		name     string
+		risk     bool
+		events   []*Event
+		escalate bool
+	}{
+		{"no suspects", false, []*Event{newMemberEvent(EventMemberJoined, testUser("U1", "ann", ""), nil, "")}, false},
+		{"any suspect without scoring", false, []*Event{suspect(0, "")}, true},
+		{"below the threshold", true, []*Event{suspect(30, "")}, false},
+		{"at the threshold", true, []*Event{suspect(60, "high")}, true},
+		{"medium below the threshold", true, []*Event{suspect(0, "medium")}, false},
+		{"high below the threshold", true, []*Event{suspect(0, "high")}, true},
+		{"critical without a score", true, []*Event{suspect(0, "critical")}, true},
+	}
+
+	riskThreshold = 60
+	t.Cleanup(func() { riskScoring = false })
+
+	for _, test := range tests {
+		riskScoring = test.risk
+		if escalate := shouldEscalate(test.events); escalate != test.escalate {
+			t.Errorf("%s: got %v, want %v", test.name, escalate, test.escalate)
+		}
+	}
+}
+
+func TestRiskEscalatesImpersonationRename(t *testing.T) {
+	riskScoring, riskThreshold, checkImpersonation = true, 60, true
+	escalateTargets = []string{"here"}
+	t.Cleanup(func() {
+		riskScoring, checkImpersonation = false, false
+		escalateTargets = []string{"everyone"}
+	})
+
+	owner := testUser("U1", "Jane Smith", "jane@example.com")
+	owner.IsOwner = true
+	before := testUser("U2", "bob", "bob@example.com")
+	after := testUser("U2", "Jane Smith", "bob@example.com")
+
+	previous := &MemberList{Ok: true, Members: []*User{owner, before}}
+	current := &MemberList{Ok: true, Members: []*User{owner, after}}
+	events := diffMembers(previous, current)
+
+	if len(events) != 1 || events[0].Severity != "critical" || events[0].Score != 0 {
+		t.Fatalf("want a critical rename without a score, got %d events", len(events))
+	}
+
+	report := renderReport(events, false)
+	if !strings.Contains(report, "<!here> WARNING possible bad actor(s) joined.") {
+		t.Fatalf("the impersonation was not escalated:\n%s", report)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"text/template"
+)
+
+/**
+Routing rules send a subset of the change events to their own destination.
+
+	The routes file is a JSON list, for example:
+
+	[
+		{"events": ["member_joined"], "channel": "#welcome",
+		 "template": "Please welcome:\n{{range .Events}}\t<@{{.User.ID}}>\n{{end}}"},
+		{"events": ["member_missing", "member_deleted"], "channel": "#people-ops"},
+		{"events": ["suspect_member", "member_role_changed"], "channel": "#security"},
+		{"events": ["channel_*"], "teams": "https://example.webhook.office.com/..."}
+	]
+
+	Event names may use shell style wildcards. A route without events
+	receives every event, unless it has a name: named routes without events
+	only receive the events a rule routes to them. Templates use text/template with a RouteReport and
+	replace the report text sent to Slack; Teams, Discord and Mattermost
+	format the matching events natively.
+**/
+
+const defaultRouteTemplate = "{{range .Events}}\t{{.Text}}\n{{end}}"
+
+// Route is a Notifier that delivers the events matching Events to its own
+// destinations, rendered with its own Template
+type Route struct {
+	Label        string   `json:"name"`
+	Events       []string `json:"events"`
+	Channel      string   `json:"channel"`
+	SlackWebhook string   `json:"slackwebhook"`
+	Teams        string   `json:"teams"`
+	Discord      string   `json:"discord"`
+	Mattermost   string   `json:"mattermost"`
+	Template     string   `json:"template"`
+
+	template  *template.Template
+	notifiers []Notifier
+}
+
+// RouteReport is the data passed to a route's template
+type RouteReport struct {
+	Route  *Route
+	Events []*Event
+}
+
+func loadRoutes(fileName string) ([]*Route, error) {
+	file, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var loaded []*Route
+	if err = json.Unmarshal(file, &loaded); err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+
+	for i, route := range loaded {
+		if route.Channel != "" {
+			route.notifiers = append(route.notifiers, &SlackNotifier{Channel: route.Channel})
+		}
+		if route.SlackWebhook != "" {
+			route.notifiers = append(route.notifiers, &SlackWebhookNotifier{route.SlackWebhook})
+		}
+		if route.Teams != "" {
+			route.notifiers = append(route.notifiers, &TeamsNotifier{route.Teams})
+		}
+		if route.Discord != "" {
+			route.notifiers = append(route.notifiers, &DiscordNotifier{route.Discord})
+		}
+		if route.Mattermost != "" {
+			route.notifiers = append(route.notifiers, &MattermostNotifier{route.Mattermost})
+		}
+		if len(route.notifiers) == 0 {
+			return nil, fmt.Errorf("%s: route %d has no destination", fileName, i+1)
+		}
+
+		for _, pattern := range route.Events {
+			if _, err = path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("%s: route %d: bad event pattern %q", fileName, i+1, pattern)
+			}
+		}
+
+		text := route.Template
+		if text == "" {
+			text = defaultRouteTemplate
+		}
+		route.template, err = template.New(fmt.Sprintf("route %d", i+1)).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fileName, err)
+		}
+	}
+
+	return loaded, nil
+}
+
+func (route *Route) matches(event *Event) bool {
+	for _, name := range event.Routes {
+		if name == route.Label {
+			return true
+		}
+	}
+
+	if len(route.Events) == 0 {
+		return route.Label == ""
+	}
+
+	for _, pattern := range route.Events {
+		if ok, _ := path.Match(pattern, event.Type); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Name identifies the notifier in error messages
+func (route *Route) Name() string {
+	if route.Label != "" {
+		return "Route " + route.Label
+	}
+	return fmt.Sprintf("Route %v", route.Events)
+}
+
+// Notify renders the matching events with the route's template and hands
+// them to the route's destinations
+func (route *Route) Notify(report *Report) error {
+	matched := []*Event{}
+	for _, event := range report.Events {
+		if route.matches(event) {
+			matched = append(matched, event)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil
+	}
+
+	var message bytes.Buffer
+	err := route.template.Execute(&message, &RouteReport{route, matched})
+	if err != nil {
+		return err
+	}
+
+	notifyAll(route.notifiers, &Report{
+		Title:  report.Title,
+		Text:   message.String(),
+		Events: matched,
+	})
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yepher/SlackRollCall/conversations"
+)
+
+func writeTestRoutes(t *testing.T, routes string) string {
+	fileName := filepath.Join(t.TempDir(), "routes.json")
+	if err := ioutil.WriteFile(fileName, []byte(routes), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestRouteNotify(t *testing.T) {
+	posted := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		message := &SlackWebhookMessage{}
+		json.NewDecoder(r.Body).Decode(message)
+		posted[r.URL.Path] = message.Text
+	}))
+	defer server.Close()
+
+	joined := newMemberEvent(EventMemberJoined, testUser("U1", "ann", "ann@example.com"), nil, "+++ New Member, ann")
+	missing := newMemberEvent(EventMemberMissing, testUser("U2", "bob", "bob@example.com"), nil, "--- Missing, bob")
+	created := newChannelEvent(EventChannelCreated, &conversations.Channel{ID: "C1", Name: "general"}, "+++ Added Channel, general")
+	routed := newMemberEvent(EventSuspectMember, testUser("U3", "eve", "eve@evil.io"), nil, "*** Suspect Member, eve")
+	routed.Routes = []string{"security"}
+
+	tests := []struct {
+		name   string
+		route  string
+		posted string
+	}{
+		{"template", `{"events": ["member_joined"], "template": "Please welcome:{{range .Events}} <@{{.User.ID}}>{{end}}"}`, "Please welcome: <@U1>"},
+		{"default template", `{"events": ["member_missing", "member_deleted"]}`, "\t--- Missing, bob\n"},
+		{"wildcard", `{"events": ["channel_*"]}`, "\t+++ Added Channel, general\n"},
+		{"every event", `{}`, "\t+++ New Member, ann\n\t--- Missing, bob\n\t+++ Added Channel, general\n\t*** Suspect Member, eve\n"},
+		{"named by a rule", `{"name": "security", "template": "{{.Route.Label}}: {{len .Events}}"}`, "security: 1"},
+		{"named with events", `{"name": "security", "events": ["member_joined"], "template": "{{range .Events}}{{.User.Name}} {{end}}"}`, "ann eve "},
+		{"no match", `{"events": ["member_restored"]}`, ""},
+	}
+
+	for _, test := range tests {
+		route := strings.TrimSuffix(test.route, "}")
+		if route != "{" {
+			route += ", "
+		}
+		fileName := writeTestRoutes(t, "["+route+`"slackwebhook": "`+server.URL+"/"+test.name+`"}]`)
+
+		routes, err := loadRoutes(fileName)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if err = routes[0].Notify(&Report{Events: []*Event{joined, missing, created, routed}}); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if text, ok := posted["/"+test.name]; text != test.posted || ok != (test.posted != "") {
+			t.Errorf("%s: posted %q, want %q", test.name, text, test.posted)
+		}
+	}
+}
+
+func TestLoadRoutesRejects(t *testing.T) {
+	tests := []struct {
+		routes  string
+		problem string
+	}{
+		{`{"events": ["member_joined"]}`, "cannot unmarshal"},
+		{`[{"events": ["member_joined"]}]`, "no destination"},
+		{`[{"events": ["member_["], "channel": "#x"}]`, "bad event pattern"},
+		{`[{"channel": "#x", "template": "{{range .Events}}"}]`, "unexpected EOF"},
+	}
+
+	for _, test := range tests {
+		_, err := loadRoutes(writeTestRoutes(t, test.routes))
+		if err == nil || !strings.Contains(err.Error(), test.problem) {
+			t.Errorf("%s: got %v, want %q", test.routes, err, test.problem)
+		}
+	}
+
+	if _, err := loadRoutes(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("a missing routes file was accepted")
+	}
+}
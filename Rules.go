@@ -0,0 +1,590 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/**
+Rules classify, tag, route and suppress every change event.
+
+	The rules file is JSON, for example:
+
+	{
+		"lists": {
+			"partners": ["partner.com", "*.contractor.io"]
+		},
+		"rules": [
+			{"name": "unknown guest",
+			 "when": "is_restricted && email_domain not in partners",
+			 "severity": "high", "tags": ["guest"], "route": "security"},
+			{"name": "quiet bots",
+			 "when": "is_bot && event == 'member_joined'",
+			 "suppress": true}
+		]
+	}
+
+	Conditions compare fields with ==, !=, in, not in, matches (a regular
+	expression) and, for numbers, <, <=, > and >=, and combine them with &&, || and !, using ( ) for grouping.
+	Strings are quoted with ' or ". A list is either [ 'a', 'b' ] or the
+	name of one of the lists. List entries starting with *. match subdomains.
+
+	Every matching rule applies: the highest severity wins, tags and routes
+	are collected and a suppress rule drops the event from the report.
+
+	Fields: event, id, name, real_name, email, email_domain, previous_email,
+	previous_email_domain, title, tz, is_bot, is_admin, is_owner,
+	is_primary_owner, is_restricted, is_ultra_restricted, is_guest, deleted,
+	has_2fa, channel, reasons, score
+**/
+
+// Severities from least to most severe
+var severities = []string{"info", "low", "medium", "high", "critical"}
+
+var ruleFieldNames = []string{
+	"event", "id", "name", "real_name", "email", "email_domain", "previous_email",
+	"previous_email_domain", "title", "tz", "is_bot", "is_admin", "is_owner",
+	"is_primary_owner", "is_restricted", "is_ultra_restricted", "is_guest",
+	"deleted", "has_2fa", "channel", "reasons", "score",
+}
+
+// RuleSet is the content of a rules file
+type RuleSet struct {
+	Lists      map[string][]string `json:"lists"`
+	Rules      []*Rule             `json:"rules"`
+	Escalation map[string][]string `json:"escalation"`
+}
+
+// Rule applies its actions to every event matching When
+type Rule struct {
+	Name     string   `json:"name"`
+	When     string   `json:"when"`
+	Severity string   `json:"severity"`
+	Tags     []string `json:"tags"`
+	Route    string   `json:"route"`
+	Suppress bool     `json:"suppress"`
+
+	condition ruleNode
+}
+
+var ruleSet *RuleSet
+
+func loadRules(fileName string) (*RuleSet, error) {
+	file, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules *RuleSet
+	if err = json.Unmarshal(file, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	if rules == nil {
+		return nil, fmt.Errorf("%s: no rules", fileName)
+	}
+
+	if err = checkEscalation(rules.Escalation); err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+
+	for i, rule := range rules.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+
+		if rule.Severity != "" && severityRank(rule.Severity) < 0 {
+			return nil, fmt.Errorf("%s: %s: unknown severity %q", fileName, rule.Name, rule.Severity)
+		}
+
+		rule.condition, err = parseCondition(rule.When, rules.Lists)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", fileName, rule.Name, err)
+		}
+	}
+
+	return rules, nil
+}
+
+// checkRuleRoutes makes sure every route named by a rule exists
+func checkRuleRoutes(rules *RuleSet, routes []*Route) error {
+	for _, rule := range rules.Rules {
+		if rule.Route == "" {
+			continue
+		}
+
+		found := false
+		for _, route := range routes {
+			if route.Label == rule.Route {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: no route named %q", rule.Name, rule.Route)
+		}
+	}
+
+	return nil
+}
+
+// applyRules runs every rule against every event, returning the events that
+// were not suppressed
+func applyRules(rules *RuleSet, events []*Event) []*Event {
+	if rules == nil {
+		return events
+	}
+
+	kept := []*Event{}
+	for _, event := range events {
+		fields := ruleFields(event)
+		suppressed := false
+
+		for _, rule := range rules.Rules {
+			if !isTruthy(rule.condition.eval(fields)) {
+				continue
+			}
+
+			if severityRank(rule.Severity) > severityRank(event.Severity) {
+				event.Severity = rule.Severity
+			}
+			event.Tags = appendUnique(event.Tags, rule.Tags...)
+			if rule.Route != "" {
+				event.Routes = appendUnique(event.Routes, rule.Route)
+			}
+			if rule.Suppress {
+				slog.Info("suppressed", "rule", rule.Name, "type", event.Type)
+				suppressed = true
+			}
+		}
+
+		if !suppressed {
+			kept = append(kept, event)
+		}
+	}
+
+	return kept
+}
+
+func severityRank(severity string) int {
+	for i, name := range severities {
+		if strings.EqualFold(name, severity) {
+			return i
+		}
+	}
+	return -1
+}
+
+func appendUnique(list []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for _, existing := range list {
+			if existing == value {
+				found = true
+			}
+		}
+		if !found {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+func ruleFields(event *Event) map[string]interface{} {
+	fields := map[string]interface{}{
+		"event":   event.Type,
+		"reasons": event.Reasons,
+		"score":   event.Score,
+	}
+
+	user := event.User
+	if user == nil {
+		user = event.Previous
+	}
+	if user == nil {
+		user = &User{}
+	}
+
+	previousEmail := ""
+	if event.Previous != nil {
+		previousEmail = event.Previous.Profile.Email
+	}
+
+	channelName := ""
+	if event.Channel != nil {
+		channelName = event.Channel.Name
+	}
+
+	fields["id"] = user.ID
+	fields["name"] = user.Name
+	fields["real_name"] = displayName(user)
+	fields["email"] = user.Profile.Email
+	fields["email_domain"] = emailDomain(user.Profile.Email)
+	fields["previous_email"] = previousEmail
+	fields["previous_email_domain"] = emailDomain(previousEmail)
+	fields["title"] = user.Profile.Title
+	fields["tz"] = user.TZ
+	fields["is_bot"] = user.IsBot
+	fields["is_admin"] = user.IsAdmin
+	fields["is_owner"] = user.IsOwner
+	fields["is_primary_owner"] = user.IsPrimaryOwner
+	fields["is_restricted"] = user.IsRestricted
+	fields["is_ultra_restricted"] = user.IsUltraRestricted
+	fields["is_guest"] = user.IsRestricted || user.IsUltraRestricted
+	fields["deleted"] = user.Deleted
+	fields["has_2fa"] = user.Has2FA
+	fields["channel"] = channelName
+
+	return fields
+}
+
+// ruleNode is a parsed piece of a rule condition
+type ruleNode interface {
+	eval(fields map[string]interface{}) interface{}
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+type fieldNode struct {
+	name string
+}
+
+type notNode struct {
+	operand ruleNode
+}
+
+type logicalNode struct {
+	op          string
+	left, right ruleNode
+}
+
+type compareNode struct {
+	op          string
+	left, right ruleNode
+	regex       *regexp.Regexp
+}
+
+func (node *literalNode) eval(fields map[string]interface{}) interface{} {
+	return node.value
+}
+
+func (node *fieldNode) eval(fields map[string]interface{}) interface{} {
+	return fields[node.name]
+}
+
+func (node *notNode) eval(fields map[string]interface{}) interface{} {
+	return !isTruthy(node.operand.eval(fields))
+}
+
+func (node *logicalNode) eval(fields map[string]interface{}) interface{} {
+	left := isTruthy(node.left.eval(fields))
+	if node.op == "&&" {
+		return left && isTruthy(node.right.eval(fields))
+	}
+	return left || isTruthy(node.right.eval(fields))
+}
+
+func (node *compareNode) eval(fields map[string]interface{}) interface{} {
+	left := node.left.eval(fields)
+	right := node.right.eval(fields)
+
+	switch node.op {
+	case "==":
+		return strings.EqualFold(fmt.Sprint(left), fmt.Sprint(right))
+	case "!=":
+		return !strings.EqualFold(fmt.Sprint(left), fmt.Sprint(right))
+	case "in":
+		return listContains(right, left)
+	case "not in":
+		return !listContains(right, left)
+	case "matches":
+		return node.regex.MatchString(fmt.Sprint(left))
+	case "<", "<=", ">", ">=":
+		a, aok := left.(int)
+		b, bok := right.(int)
+		if !aok || !bok {
+			return false
+		}
+		switch node.op {
+		case "<":
+			return a < b
+		case "<=":
+			return a <= b
+		case ">":
+			return a > b
+		}
+		return a >= b
+	}
+
+	return false
+}
+
+func isTruthy(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case []string:
+		return len(v) > 0
+	case int:
+		return v != 0
+	}
+	return false
+}
+
+func listContains(list interface{}, value interface{}) bool {
+	items, ok := list.([]string)
+	if !ok {
+		return false
+	}
+
+	text := strings.ToLower(fmt.Sprint(value))
+	for _, item := range items {
+		item = strings.ToLower(item)
+		if item == text || (strings.HasPrefix(item, "*.") && domainMatches(text, item)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ruleToken is a lexical token of a rule condition
+type ruleToken struct {
+	text     string
+	isString bool
+}
+
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+	lists  map[string][]string
+}
+
+func parseCondition(text string, lists map[string][]string) (ruleNode, error) {
+	if strings.TrimSpace(text) == "" {
+		return &literalNode{true}, nil
+	}
+
+	tokens, err := tokenizeCondition(text)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &ruleParser{tokens: tokens, lists: lists}
+	node, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(parser.tokens) {
+		return nil, fmt.Errorf("unexpected %q", parser.tokens[parser.pos].text)
+	}
+
+	return node, nil
+}
+
+func tokenizeCondition(text string) ([]ruleToken, error) {
+	tokens := []ruleToken{}
+	runes := []rune(text)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'' || r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, ruleToken{string(runes[i+1 : end]), true})
+			i = end + 1
+		case strings.ContainsRune("()[],", r):
+			tokens = append(tokens, ruleToken{text: string(r)})
+			i++
+		case i+1 < len(runes) && isRuleOperator(string(runes[i:i+2])):
+			tokens = append(tokens, ruleToken{text: string(runes[i : i+2])})
+			i += 2
+		case r == '!' || r == '<' || r == '>':
+			tokens = append(tokens, ruleToken{text: string(r)})
+			i++
+		case unicode.IsDigit(r):
+			end := i
+			for end < len(runes) && unicode.IsDigit(runes[end]) {
+				end++
+			}
+			tokens = append(tokens, ruleToken{text: string(runes[i:end])})
+			i = end
+		case unicode.IsLetter(r) || r == '_':
+			end := i
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || runes[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, ruleToken{text: string(runes[i:end])})
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected %q", string(r))
+		}
+	}
+
+	return tokens, nil
+}
+
+func isRuleOperator(text string) bool {
+	return text == "&&" || text == "||" || text == "==" || text == "!=" ||
+		text == "<=" || text == ">="
+}
+
+func (parser *ruleParser) peek() string {
+	if parser.pos < len(parser.tokens) && !parser.tokens[parser.pos].isString {
+		return parser.tokens[parser.pos].text
+	}
+	return ""
+}
+
+func (parser *ruleParser) expect(text string) error {
+	if parser.peek() != text {
+		return fmt.Errorf("expected %q", text)
+	}
+	parser.pos++
+	return nil
+}
+
+func (parser *ruleParser) parseOr() (ruleNode, error) {
+	left, err := parser.parseAnd()
+	for err == nil && parser.peek() == "||" {
+		parser.pos++
+		var right ruleNode
+		right, err = parser.parseAnd()
+		left = &logicalNode{"||", left, right}
+	}
+	return left, err
+}
+
+func (parser *ruleParser) parseAnd() (ruleNode, error) {
+	left, err := parser.parseNot()
+	for err == nil && parser.peek() == "&&" {
+		parser.pos++
+		var right ruleNode
+		right, err = parser.parseNot()
+		left = &logicalNode{"&&", left, right}
+	}
+	return left, err
+}
+
+func (parser *ruleParser) parseNot() (ruleNode, error) {
+	if parser.peek() == "!" {
+		parser.pos++
+		operand, err := parser.parseNot()
+		return &notNode{operand}, err
+	}
+	return parser.parseCompare()
+}
+
+func (parser *ruleParser) parseCompare() (ruleNode, error) {
+	left, err := parser.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	op := parser.peek()
+	switch op {
+	case "==", "!=", "in", "matches", "<", "<=", ">", ">=":
+		parser.pos++
+	case "not":
+		parser.pos++
+		if err = parser.expect("in"); err != nil {
+			return nil, err
+		}
+		op = "not in"
+	default:
+		return left, nil
+	}
+
+	right, err := parser.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &compareNode{op: op, left: left, right: right}
+	if op == "matches" {
+		text := ""
+		if pattern, ok := right.(*literalNode); ok {
+			text, ok = pattern.value.(string)
+		}
+		if text == "" {
+			return nil, fmt.Errorf("matches needs a quoted regular expression")
+		}
+		if node.regex, err = regexp.Compile(text); err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+func (parser *ruleParser) parseOperand() (ruleNode, error) {
+	if parser.pos >= len(parser.tokens) {
+		return nil, fmt.Errorf("unexpected end of condition")
+	}
+
+	token := parser.tokens[parser.pos]
+	parser.pos++
+
+	if token.isString {
+		return &literalNode{token.text}, nil
+	}
+
+	switch token.text {
+	case "(":
+		node, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return node, parser.expect(")")
+	case "[":
+		list := []string{}
+		for parser.peek() != "]" {
+			if parser.pos >= len(parser.tokens) || !parser.tokens[parser.pos].isString {
+				return nil, fmt.Errorf("lists may only contain quoted strings")
+			}
+			list = append(list, parser.tokens[parser.pos].text)
+			parser.pos++
+			if parser.peek() == "," {
+				parser.pos++
+			}
+		}
+		parser.pos++
+		return &literalNode{list}, nil
+	case "true":
+		return &literalNode{true}, nil
+	case "false":
+		return &literalNode{false}, nil
+	}
+
+	if number, err := strconv.Atoi(token.text); err == nil {
+		return &literalNode{number}, nil
+	}
+
+	for _, name := range ruleFieldNames {
+		if name == token.text {
+			return &fieldNode{name}, nil
+		}
+	}
+
+	if list, ok := parser.lists[token.text]; ok {
+		return &literalNode{list}, nil
+	}
+
+	return nil, fmt.Errorf("unknown field or list %q", token.text)
+}
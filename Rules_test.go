@@ -0,0 +1,141 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseCondition(t *testing.T) {
+	lists := map[string][]string{"partners": {"partner.com", "*.contractor.io"}}
+
+	guest := testUser("U1", "ann", "ann@dev.contractor.io")
+	guest.IsRestricted = true
+	guest.Profile.Title = "Chief Executive Officer (CEO)"
+	joined := newMemberEvent(EventMemberJoined, guest, nil, "")
+	joined.Score = 70
+
+	changed := newMemberEvent(EventEmailChanged, testUser("U2", "bob", "bob@gmail.com"), testUser("U2", "bob", "bob@ourcompany.com"), "")
+	changed.Reasons = []string{"monitored", "free email provider"}
+
+	tests := []struct {
+		condition string
+		event     *Event
+		matches   bool
+	}{
+		// A rule without a condition applies to every event
+		{"", joined, true},
+		{"is_restricted", joined, true},
+		{"is_guest && !is_bot", joined, true},
+		{"is_bot", joined, false},
+		{"event == 'member_joined'", joined, true},
+		{`event == "MEMBER_JOINED"`, joined, true},
+		{"event != 'member_joined'", joined, false},
+		{"email_domain in partners", joined, true},
+		{"email_domain not in partners", joined, false},
+		{"email_domain in ['ourcompany.com', 'partner.com']", joined, false},
+		{"title matches '(?i)\\bceo\\b'", joined, true},
+		{"score >= 70 && score < 71", joined, true},
+		{"score > 70", joined, false},
+		{"is_bot || score <= 60", joined, false},
+		{"!(is_bot || deleted) && name == 'ann'", joined, true},
+		{"is_bot && is_guest || event == 'member_joined'", joined, true},
+		{"is_bot && (is_guest || event == 'member_joined')", joined, false},
+		{"previous_email_domain == 'ourcompany.com' && email_domain != 'ourcompany.com'", changed, true},
+		{"'monitored' in reasons", changed, true},
+		{"'disposable email' in reasons", changed, false},
+		{"reasons", changed, true},
+		{"reasons", joined, false},
+		{"is_admin == false", changed, true},
+	}
+
+	for _, test := range tests {
+		node, err := parseCondition(test.condition, lists)
+		if err != nil {
+			t.Errorf("%s: %v", test.condition, err)
+			continue
+		}
+		if matches := isTruthy(node.eval(ruleFields(test.event))); matches != test.matches {
+			t.Errorf("%s: got %v, want %v", test.condition, matches, test.matches)
+		}
+	}
+}
+
+func TestParseConditionRejects(t *testing.T) {
+	for _, condition := range []string{
+		"foo",
+		"is_bot &&",
+		"email ==",
+		"(is_bot",
+		"is_bot)",
+		"email = 'x'",
+		"email matches x",
+		"email matches '('",
+		"'x' in [1]",
+		"email_domain in nosuchlist",
+		"email not 'x'",
+		"'unterminated",
+	} {
+		if _, err := parseCondition(condition, nil); err == nil {
+			t.Errorf("%q was accepted", condition)
+		}
+	}
+}
+
+func TestApplyRules(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "rules.json")
+	writeTestCache(t, fileName, map[string]interface{}{
+		"lists": map[string][]string{"partners": {"partner.com", "*.contractor.io"}},
+		"rules": []map[string]interface{}{
+			{"name": "guest", "when": "is_restricted && email_domain not in partners", "severity": "high", "tags": []string{"guest"}, "route": "security"},
+			{"name": "ceo", "when": "(event == 'suspect_member' || title matches '(?i)ceo') && !is_bot", "severity": "critical"},
+			{"name": "quiet bots", "when": "is_bot && event == 'member_joined'", "suppress": true},
+			{"name": "monitored", "when": "'monitored' in reasons", "tags": []string{"monitored"}},
+		},
+	})
+
+	rules, err := loadRules(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = checkRuleRoutes(rules, nil); err == nil {
+		t.Fatal("a route without a destination was accepted")
+	}
+
+	partner := testUser("U1", "ann", "ann@dev.contractor.io")
+	partner.IsRestricted = true
+	stranger := testUser("U2", "eve", "eve@evil.io")
+	stranger.IsRestricted = true
+	stranger.Profile.Title = "The CEO"
+	bot := &User{ID: "U3", Name: "deploybot", IsBot: true}
+	suspect := newMemberEvent(EventSuspectMember, testUser("U4", "mallory", "mallory@example.com"), nil, "")
+	suspect.Reasons = []string{"monitored"}
+
+	events := []*Event{
+		newMemberEvent(EventMemberJoined, partner, nil, ""),
+		newMemberEvent(EventMemberJoined, stranger, nil, ""),
+		newMemberEvent(EventMemberJoined, bot, nil, ""),
+		suspect,
+	}
+	kept := applyRules(rules, events)
+
+	if len(kept) != 3 || kept[2] != suspect {
+		t.Fatalf("got %d events, want the bot join suppressed", len(kept))
+	}
+
+	tests := []struct {
+		event    *Event
+		severity string
+		tags     []string
+		routes   []string
+	}{
+		{kept[0], "", nil, nil},
+		{kept[1], "critical", []string{"guest"}, []string{"security"}},
+		{kept[2], "critical", []string{"monitored"}, nil},
+	}
+	for _, test := range tests {
+		if test.event.Severity != test.severity || !sameNames(test.event.Tags, test.tags) || !sameNames(test.event.Routes, test.routes) {
+			t.Errorf("%s: got %q %v %v, want %q %v %v", test.event.User.Name,
+				test.event.Severity, test.event.Tags, test.event.Routes, test.severity, test.tags, test.routes)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yepher/SlackRollCall/conversations"
+)
+
+/**
+Events API: https://api.slack.com/apis/connections/events-api
+	Subscribe the Slack app to team_join, user_change, channel_created,
+	channel_deleted, channel_rename, channel_archive and channel_unarchive and
+	point its request URL at /slack/events on --listen.
+
+	Every event updates the cached member or channel list and is compared
+	with the cache through the same pipeline as a full run, so joins are
+	reported as they happen. Full runs on the --watch schedule (every hour by
+	default) reconcile the cache with users.list and conversations.list in
+	case events were missed.
+**/
+
+// defaultReconcileSchedule is the full run schedule when only --listen is set
+const defaultReconcileSchedule = "1h"
+
+// slackEventQueueSize is how many events may wait while one is processed
+const slackEventQueueSize = 100
+
+// slackEventMemory is how long event IDs are remembered to skip Slack's retries
+const slackEventMemory = time.Hour
+
+var memberCacheFile = ""
+var channelCacheFile = ""
+
+// pipelineLock keeps full runs and Slack events from updating the caches at the same time
+var pipelineLock sync.Mutex
+
+// SlackEventEnvelope is the outer part of every Events API request
+type SlackEventEnvelope struct {
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	EventID   string          `json:"event_id"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// SlackEvent is the inner event; channel is an object or a channel ID
+// depending on the event type
+type SlackEvent struct {
+	Type    string          `json:"type"`
+	User    json.RawMessage `json:"user"`
+	Channel json.RawMessage `json:"channel"`
+}
+
+type slackEventsHandler struct {
+	signingSecret string
+	queue         chan *SlackEvent
+	seen          map[string]time.Time
+	lock          sync.Mutex
+}
+
+func newSlackEventsHandler(signingSecret string) *slackEventsHandler {
+	handler := &slackEventsHandler{
+		signingSecret: signingSecret,
+		queue:         make(chan *SlackEvent, slackEventQueueSize),
+		seen:          map[string]time.Time{},
+	}
+	go handler.process()
+	return handler
+}
+
+// ServeHTTP answers Slack's URL verification and queues events, replying
+// before they are processed as Slack expects an answer within 3 seconds
+func (handler *slackEventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "unreadable request", http.StatusBadRequest)
+		return
+	}
+
+	if !verifySlackSignature(handler.signingSecret, r.Header, body) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var envelope SlackEventEnvelope
+	if err = json.Unmarshal(body, &envelope); err != nil {
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+
+	if envelope.Type == "url_verification" {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, envelope.Challenge)
+		return
+	}
+
+	switch handler.receive(&envelope) {
+	case errInvalidEvent:
+		http.Error(w, "invalid event", http.StatusBadRequest)
+	case errEventQueueFull:
+		http.Error(w, "too many events", http.StatusServiceUnavailable)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+var errInvalidEvent = errors.New("invalid event")
+var errEventQueueFull = errors.New("too many events")
+
+// receive queues the event of an event_callback, skipping Slack's retries
+func (handler *slackEventsHandler) receive(envelope *SlackEventEnvelope) error {
+	if envelope.Type != "event_callback" || handler.isRetry(envelope.EventID) {
+		return nil
+	}
+
+	event := &SlackEvent{}
+	if err := json.Unmarshal(envelope.Event, event); err != nil {
+		return errInvalidEvent
+	}
+
+	select {
+	case handler.queue <- event:
+		return nil
+	default:
+		handler.forget(envelope.EventID)
+		return errEventQueueFull
+	}
+}
+
+// isRetry remembers the event ID and reports whether it was seen before
+func (handler *slackEventsHandler) isRetry(eventID string) bool {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+
+	now := time.Now()
+	for id, seen := range handler.seen {
+		if now.Sub(seen) > slackEventMemory {
+			delete(handler.seen, id)
+		}
+	}
+
+	if _, ok := handler.seen[eventID]; ok && eventID != "" {
+		return true
+	}
+	handler.seen[eventID] = now
+	return false
+}
+
+func (handler *slackEventsHandler) forget(eventID string) {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	delete(handler.seen, eventID)
+}
+
+func (handler *slackEventsHandler) process() {
+	for event := range handler.queue {
+		if err := handleSlackEvent(event); err != nil {
+			slog.Error("handling Slack event", "type", event.Type, "error", err)
+		}
+	}
+}
+
+// handleSlackEvent applies the event to the cached list it belongs to and
+// reports the resulting changes
+func handleSlackEvent(event *SlackEvent) error {
+	pipelineLock.Lock()
+	defer pipelineLock.Unlock()
+
+	if err := reloadAcks(); err != nil {
+		return err
+	}
+
+	switch event.Type {
+	case "team_join", "user_change":
+		if memberCacheFile == "" {
+			return nil
+		}
+
+		user := &User{}
+		if err := json.Unmarshal(event.User, user); err != nil || user.ID == "" {
+			return fmt.Errorf("invalid user")
+		}
+
+		previousList := loadMembersFromFile(memberCacheFile)
+		if previousList == nil {
+			return fmt.Errorf("no member list cached yet")
+		}
+
+		// Only this member changed, so diff it alone against its cached record
+		previousRecord := findMember(user.ID, previousList)
+		currentList := &MemberList{Ok: true, Members: replaceMember(previousList.Members, user)}
+		newMembers := 0
+		if previousRecord == nil {
+			newMembers = 1
+		}
+		events := diffMember(previousRecord, user, protectedIdentities(currentList), newMembers)
+
+		json, _ := json.Marshal(currentList)
+		writeCache(memberCacheFile, json)
+
+		processEvents(events, false, time.Now())
+
+	case "channel_created", "channel_rename", "channel_deleted", "channel_archive", "channel_unarchive":
+		if channelCacheFile == "" {
+			return nil
+		}
+
+		previousList := conversations.LoadFromFile(channelCacheFile)
+		if previousList == nil {
+			return fmt.Errorf("no channel list cached yet")
+		}
+
+		currentList, err := applyChannelEvent(previousList, event)
+		if err != nil {
+			return err
+		}
+		events := diffChannels(previousList, currentList)
+
+		json, _ := json.Marshal(currentList)
+		writeCache(channelCacheFile, json)
+
+		processEvents(events, true, time.Now())
+	}
+
+	return nil
+}
+
+// replaceMember returns a copy of the members with the user added or replaced
+func replaceMember(members []*User, user *User) []*User {
+	replaced := []*User{}
+	found := false
+	for _, member := range members {
+		if member.ID == user.ID {
+			replaced = append(replaced, user)
+			found = true
+		} else {
+			replaced = append(replaced, member)
+		}
+	}
+	if !found {
+		replaced = append(replaced, user)
+	}
+	return replaced
+}
+
+// applyChannelEvent returns a copy of the channel list with the event applied
+func applyChannelEvent(previousList *conversations.List, event *SlackEvent) (*conversations.List, error) {
+	changed := &conversations.Channel{}
+	if err := json.Unmarshal(event.Channel, changed); err != nil {
+		if err = json.Unmarshal(event.Channel, &changed.ID); err != nil {
+			return nil, fmt.Errorf("invalid channel")
+		}
+	}
+
+	currentList := &conversations.List{Ok: true}
+	for _, element := range previousList.Channels {
+		if element.ID != changed.ID {
+			currentList.Channels = append(currentList.Channels, element)
+			continue
+		}
+
+		copied := *element
+		switch event.Type {
+		case "channel_rename":
+			copied.Name = changed.Name
+			copied.NameNormalized = changed.Name
+		case "channel_archive":
+			copied.IsArchived = true
+		case "channel_unarchive":
+			copied.IsArchived = false
+		case "channel_deleted":
+			continue
+		}
+		currentList.Channels = append(currentList.Channels, &copied)
+	}
+
+	if event.Type == "channel_created" && conversations.Find(changed.ID, previousList) == nil {
+		currentList.Channels = append(currentList.Channels, changed)
+	}
+
+	return currentList, nil
+}
+
+// startReceiver serves the Events API, and the Acknowledge buttons when an
+// acknowledgement file is set, until stopReceiver is called
+func startReceiver(listen string, signingSecret string) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/slack/events", newSlackEventsHandler(signingSecret))
+
+	if ackFile != "" {
+		handler, err := newAckHandler(ackFile, signingSecret, "", ackReviewers)
+		if err != nil {
+			return nil, err
+		}
+		mux.Handle("/slack/interactions", handler)
+	}
+
+	return startServer(listen, mux, "Slack events")
+}
+
+// startServer listens on the address and serves the handler in the
+// background until stopServer is called. An address that can't be listened
+// on fails here rather than after start up.
+func startServer(listen string, handler http.Handler, what string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{Addr: listen, Handler: handler}
+	go func() {
+		slog.Info("serving", "what", what, "address", listener.Addr().String())
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("server failed", "what", what, "error", err)
+		}
+	}()
+	return server, nil
+}
+
+func stopServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
+}
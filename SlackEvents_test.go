@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandleMemberEvent(t *testing.T) {
+	admin := testUser("U1", "alice", "alice@example.com")
+	admin.IsAdmin = true
+
+	promoted := testUser("U2", "bob", "bob@example.com")
+	promoted.IsAdmin = true
+
+	tests := []struct {
+		name   string
+		event  string
+		user   *User
+		want   []string
+		cached int
+	}{
+		{"join", "team_join", testUser("U3", "carol", "carol@example.com"), []string{EventMemberJoined}, 3},
+		{"role change", "user_change", promoted, []string{EventRoleChanged}, 2},
+		{"email change", "user_change", testUser("U2", "bob", "bob@example.org"), []string{EventEmailChanged}, 2},
+		{"deleted", "user_change", &User{ID: "U2", Name: "bob", RealName: "bob", Deleted: true}, []string{EventMemberDeleted}, 2},
+		{"no change", "user_change", testUser("U2", "bob", "bob@example.com"), []string{}, 2},
+		{"impersonation", "user_change", testUser("U2", "alice", "bob@example.com"), []string{EventSuspectMember}, 2},
+	}
+
+	checkImpersonation = true
+	t.Cleanup(func() { checkImpersonation = false })
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			memberCacheFile = filepath.Join(t.TempDir(), "userList.cache")
+			writeTestCache(t, memberCacheFile, &MemberList{Ok: true, Members: []*User{admin, testUser("U2", "bob", "bob@example.com")}})
+
+			recorder := &reportRecorder{}
+			notifiers = []Notifier{recorder}
+			t.Cleanup(func() {
+				memberCacheFile = ""
+				notifiers = []Notifier{}
+			})
+
+			user, _ := json.Marshal(test.user)
+			if err := handleSlackEvent(&SlackEvent{Type: test.event, User: user}); err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, report := range recorder.reports {
+				for _, event := range report.Events {
+					got = append(got, event.Type)
+				}
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got events %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("got events %v, want %v", got, test.want)
+				}
+			}
+
+			cached := loadMembersFromFile(memberCacheFile)
+			if len(cached.Members) != test.cached {
+				t.Fatalf("got %d cached members, want %d", len(cached.Members), test.cached)
+			}
+			if record := findMember(test.user.ID, cached); record == nil || record.Profile.Email != test.user.Profile.Email {
+				t.Fatal("the cached record was not replaced")
+			}
+		})
+	}
+}
+
+func TestStartServerFailsWhenTheAddressIsTaken(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	if server, err := startServer(taken.Addr().String(), http.NotFoundHandler(), "test"); err == nil {
+		stopServer(server)
+		t.Fatal("started on an address that is in use")
+	}
+
+	server, err := startServer("127.0.0.1:0", http.NotFoundHandler(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopServer(server)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/codegangsta/cli"
+)
+
+func TestSlackWebhookNotifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		reply   string
+		failure bool
+	}{
+		{"delivered", http.StatusOK, "ok", false},
+		{"unknown webhook", http.StatusNotFound, "no_service", true},
+		{"archived channel", http.StatusGone, "channel_is_archived", true},
+		{"bad payload", http.StatusBadRequest, "invalid_payload", true},
+	}
+
+	for _, test := range tests {
+		var posted *SlackWebhookMessage
+		var contentType string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			contentType = r.Header.Get("Content-Type")
+			posted = &SlackWebhookMessage{}
+			json.NewDecoder(r.Body).Decode(posted)
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.reply))
+		}))
+
+		notifier := &SlackWebhookNotifier{URL: server.URL}
+		err := notifier.Notify(&Report{Text: "Searching for new members\n+++ New Member, ann"})
+		server.Close()
+
+		if failed := err != nil; failed != test.failure {
+			t.Errorf("%s: got %v", test.name, err)
+		}
+		if posted == nil || posted.Text != "Searching for new members\n+++ New Member, ann" {
+			t.Errorf("%s: posted %#v, want the report text", test.name, posted)
+		}
+		if contentType != "application/json; charset=utf-8" {
+			t.Errorf("%s: posted as %q", test.name, contentType)
+		}
+	}
+}
+
+func TestSlackWebhookNotifiers(t *testing.T) {
+	// An empty variable still counts as set
+	t.Setenv("SLACK_API_KEY", "")
+	os.Unsetenv("SLACK_API_KEY")
+	t.Cleanup(func() { notifiers = []Notifier{} })
+
+	first, second := "https://hooks.slack.com/services/T0/B1/x", "https://hooks.slack.com/services/T0/B2/y"
+	tests := []struct {
+		args  []string
+		names []string
+		urls  []string
+	}{
+		{[]string{}, []string{}, []string{}},
+		{[]string{"--slackwebhook", first}, []string{"Slack webhook"}, []string{first}},
+		{[]string{"--slackwebhook", " " + first + ", " + second + " "}, []string{"Slack webhook", "Slack webhook"}, []string{first, second}},
+		{[]string{"--channel", "security", "--slackwebhook", first}, []string{"Slack security", "Slack webhook"}, []string{first}},
+	}
+
+	for _, test := range tests {
+		var err error
+		app := newApp()
+		app.Action = func(c *cli.Context) {
+			err = configure(c)
+		}
+		app.Run(append([]string{"SlackRollCall", "-k", "k"}, test.args...))
+		if err != nil {
+			t.Fatalf("%v: %v", test.args, err)
+		}
+
+		names, urls := []string{}, []string{}
+		for _, notifier := range notifiers {
+			names = append(names, notifier.Name())
+			if webhook, ok := notifier.(*SlackWebhookNotifier); ok {
+				urls = append(urls, webhook.URL)
+			}
+		}
+		if !sameNames(names, test.names) || !sameNames(urls, test.urls) {
+			t.Errorf("%v: got notifiers %v for %v, want %v for %v", test.args, names, urls, test.names, test.urls)
+		}
+	}
+}
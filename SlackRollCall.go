@@ -2,15 +2,22 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/codegangsta/cli"
+	"github.com/yepher/SlackRollCall/cache"
+	"github.com/yepher/SlackRollCall/logging"
+	"github.com/yepher/SlackRollCall/options"
+	"github.com/yepher/SlackRollCall/schedule"
 )
 
 /**
@@ -25,8 +32,13 @@ var isVerbose = false
 var saveCache = false
 
 var apiKey = ""
-var channel = ""
-var monitored = []string{}
+
+// slackAPIURL is where the Slack Web API is called, replaced by a stand-in in tests
+var slackAPIURL = "https://slack.com/api/"
+var monitored = []*MonitorRule{}
+
+// runSchedule is when full runs happen with --watch, --listen, --apptoken or --serve
+var runSchedule schedule.Schedule
 
 // UserProfile contains all the information details of a given user
 type UserProfile struct {
@@ -43,6 +55,7 @@ type UserProfile struct {
 	Image72            string `json:"image_72"`
 	Image192           string `json:"image_192"`
 	ImageOriginal      string `json:"image_original"`
+	IsCustomImage      bool   `json:"is_custom_image"`
 	Title              string `json:"title"`
 	BotId              string `json:"bot_id"`
 }
@@ -91,17 +104,24 @@ type ResponseMetadata struct {
 }
 
 type SlackMessage struct {
-	Channel string `json:"channel"`
-	Text    string `json:"text"`
+	Channel string        `json:"channel"`
+	Text    string        `json:"text"`
+	Blocks  []interface{} `json:"blocks,omitempty"`
 }
 
 func main() {
+	newApp().Run(os.Args)
+}
+
+// newApp returns the command line application with its options and commands
+func newApp() *cli.App {
 	app := cli.NewApp()
 	app.Version = "0.0.5"
 	//app.Name = "Slack Role Call"
 	app.Usage = "Track a Slack team's membership changes"
 	//app.UsageText = "TODO describe application usage"
 	app.Flags = []cli.Flag{
+		configFlag,
 		cli.StringFlag{
 			Name:   "apikey, k",
 			Value:  "",
@@ -111,7 +131,27 @@ func main() {
 		cli.StringFlag{
 			Name:  "verbose",
 			Value: "false",
-			Usage: "Dumps additional information to console",
+			Usage: "Logs debug information, same as --loglevel debug",
+		},
+		cli.StringFlag{
+			Name:  "dry-run",
+			Value: "false",
+			Usage: "Optional, print what would be posted and saved instead of posting or saving it",
+		},
+		cli.StringFlag{
+			Name:  "loglevel",
+			Value: "info",
+			Usage: "Optional, least severe log messages written to stderr: debug, info, warn or error",
+		},
+		cli.StringFlag{
+			Name:  "logformat",
+			Value: "text",
+			Usage: "Optional, log format: text or json",
+		},
+		cli.StringFlag{
+			Name:  "logpii",
+			Value: "false",
+			Usage: "Optional, log emails and phone numbers unmasked",
 		},
 		cli.StringFlag{
 			Name:  "cache, c",
@@ -128,200 +168,988 @@ func main() {
 			Value: "",
 			Usage: "Optional, Slack channel to deliver results to. If not set a message will not be sent to Slack.",
 		},
+		cli.StringFlag{
+			Name:  "routes, r",
+			Value: "",
+			Usage: "Optional, JSON file of rules routing change events to their own channels.",
+		},
+		cli.StringFlag{
+			Name:  "rules",
+			Value: "",
+			Usage: "Optional, JSON file of rules that classify, tag, route or suppress change events.",
+		},
+		cli.StringFlag{
+			Name:  "channelcache",
+			Value: "",
+			Usage: "Optional, set channel cache file to use. If not set channel changes are not tracked.",
+		},
+		cli.StringFlag{
+			Name:  "ignore, i",
+			Value: "",
+			Usage: "Optional, Ignore channels with these prefixes.",
+		},
+		cli.StringFlag{
+			Name:   "slackwebhook",
+			Value:  "",
+			Usage:  "Optional, A list of Slack incoming webhook URLs to deliver results to.",
+			EnvVar: "SLACK_WEBHOOK_URL",
+		},
+		cli.StringFlag{
+			Name:  "allow, a",
+			Value: "",
+			Usage: "Optional, A list of approved email domains. New members from any other domain are reported as suspect.",
+		},
+		cli.StringFlag{
+			Name:  "guestallow",
+			Value: "",
+			Usage: "Optional, A list of approved email domains for guests. Defaults to --allow.",
+		},
+		cli.StringFlag{
+			Name:  "lookalike",
+			Value: "",
+			Usage: "Optional, A list of our legitimate email domains. New members from lookalike domains are reported as suspect.",
+		},
+		cli.StringFlag{
+			Name:  "lookalikedistance",
+			Value: "2",
+			Usage: "Optional, number of typos a domain may be away from a legitimate domain to count as a lookalike",
+		},
+		cli.StringFlag{
+			Name:  "impersonation",
+			Value: "false",
+			Usage: "Optional, report new or renamed members whose name is close to an admin, owner or VIP as critical",
+		},
+		cli.StringFlag{
+			Name:  "vip",
+			Value: "",
+			Usage: "Optional, A list of names (e.g. your CEO) to protect from impersonation, each optionally followed by :<user ID>. Turns on --impersonation.",
+		},
+		cli.StringFlag{
+			Name:  "history",
+			Value: "",
+			Usage: "Optional, file recording member joins across runs, enables raid detection",
+		},
+		cli.StringFlag{
+			Name:  "raidwindow",
+			Value: "15m",
+			Usage: "Optional, time window in which join bursts are counted",
+		},
+		cli.StringFlag{
+			Name:  "raidthreshold",
+			Value: "10",
+			Usage: "Optional, number of joins within --raidwindow reported as a raid",
+		},
+		cli.StringFlag{
+			Name:  "raidfactor",
+			Value: "0",
+			Usage: "Optional, also report a raid when joins within --raidwindow are this many times the usual number",
+		},
+		cli.StringFlag{
+			Name:  "watch, w",
+			Value: "",
+			Usage: "Optional, keep running and check on a schedule: an interval such as 15m or a cron expression such as \"*/15 * * * *\"",
+		},
+		cli.StringFlag{
+			Name:  "listen",
+			Value: "",
+			Usage: "Optional, address to receive Slack events on, e.g. :8080, with full runs on the --watch schedule",
+		},
+		cli.StringFlag{
+			Name:   "signingsecret",
+			Value:  "",
+			Usage:  "Optional, Slack app signing secret used to verify received events",
+			EnvVar: "SLACK_SIGNING_SECRET",
+		},
+		cli.StringFlag{
+			Name:   "apptoken",
+			Value:  "",
+			Usage:  "Optional, Slack app-level token to receive Slack events with Socket Mode, with full runs on the --watch schedule",
+			EnvVar: "SLACK_APP_TOKEN",
+		},
+		cli.StringFlag{
+			Name:  "serve",
+			Value: "",
+			Usage: "Optional, address to serve the dashboard on, e.g. 127.0.0.1:8081, with full runs on the --watch schedule",
+		},
+		cli.StringFlag{
+			Name:   "apitoken",
+			Value:  "",
+			Usage:  "Optional, comma separated tokens that enable the JSON API on --serve and protect the dashboard",
+			EnvVar: "ROLLCALL_API_TOKEN",
+		},
+		cli.StringFlag{
+			Name:  "status",
+			Value: "",
+			Usage: "Optional, file the last attempt, success and failure of each check are kept in between runs",
+		},
+		cli.StringFlag{
+			Name:  "deadman",
+			Value: "",
+			Usage: "Optional, notify when no check succeeded for this long, e.g. 26h",
+		},
+		cli.StringFlag{
+			Name:  "eventlog",
+			Value: "",
+			Usage: "Optional, file every reported change is appended to, shown on the dashboard timeline",
+		},
+		cli.StringFlag{
+			Name:  "acks",
+			Value: "",
+			Usage: "Optional, acknowledgement file of vetted members that are no longer reported as suspects",
+		},
+		cli.StringFlag{
+			Name:  "ackbuttons",
+			Value: "false",
+			Usage: "Optional, follow the Slack channel report with an Acknowledge button per suspect member",
+		},
+		cli.StringFlag{
+			Name:  "ackreviewers",
+			Value: "",
+			Usage: "Optional, comma separated user or user group IDs allowed to click Acknowledge, anyone when empty",
+		},
+		cli.StringFlag{
+			Name:  "escalate",
+			Value: "everyone",
+			Usage: "Optional, comma separated mentions for suspect members and raids: everyone, channel, here, user or group IDs, or none",
+		},
+		cli.StringFlag{
+			Name:  "risk",
+			Value: "false",
+			Usage: "Optional, score every new member's risk and include it in the report",
+		},
+		cli.StringFlag{
+			Name:  "riskthreshold",
+			Value: "60",
+			Usage: "Optional, risk score from which a new member is suspect and escalated",
+		},
+		cli.StringFlag{
+			Name:  "disposable",
+			Value: "false",
+			Usage: "Optional, report new members using disposable email addresses as suspect",
+		},
+		cli.StringFlag{
+			Name:  "disposablelist",
+			Value: "",
+			Usage: "Optional, A list of files with more disposable email domains, one per line. Turns on --disposable.",
+		},
+		cli.StringFlag{
+			Name:  "freemail",
+			Value: "false",
+			Usage: "Optional, report new members using free email providers (gmail.com, ...) as suspect",
+		},
+		cli.StringFlag{
+			Name:  "freemaillist",
+			Value: "",
+			Usage: "Optional, A list of files with more free email domains, one per line. Turns on --freemail.",
+		},
+		cli.StringFlag{
+			Name:  "teams",
+			Value: "",
+			Usage: "Optional, A list of Microsoft Teams connector URLs to deliver results to.",
+		},
+		cli.StringFlag{
+			Name:  "discord",
+			Value: "",
+			Usage: "Optional, A list of Discord webhook URLs to deliver results to.",
+		},
+		cli.StringFlag{
+			Name:  "mattermost",
+			Value: "",
+			Usage: "Optional, A list of Mattermost incoming webhook URLs to deliver results to.",
+		},
 		cli.StringFlag{
 			Name:  "monitor, m",
 			Value: "",
-			Usage: "Optional, A list of domains to monitor when a new user appears.",
+			Usage: "Optional, A list of domains, *.subdomains or /regex/ rules to monitor when a new user appears.",
+		},
+		cli.StringFlag{
+			Name:  "smtphost",
+			Value: "",
+			Usage: "Optional, SMTP server (host:port) to email results through. If not set an email will not be sent.",
+		},
+		cli.StringFlag{
+			Name:   "smtpuser",
+			Value:  "",
+			Usage:  "Optional, SMTP user name",
+			EnvVar: "SMTP_USER",
+		},
+		cli.StringFlag{
+			Name:   "smtppassword",
+			Value:  "",
+			Usage:  "Optional, SMTP password",
+			EnvVar: "SMTP_PASSWORD",
+		},
+		cli.StringFlag{
+			Name:  "smtpstarttls",
+			Value: "auto",
+			Usage: "Optional, use STARTTLS: auto, true or false",
+		},
+		cli.StringFlag{
+			Name:  "emailfrom",
+			Value: "",
+			Usage: "Optional, address the results email is sent from",
+		},
+		cli.StringFlag{
+			Name:  "emailto",
+			Value: "",
+			Usage: "Optional, A list of addresses to email results to.",
+		},
+		cli.StringFlag{
+			Name:  "webhook",
+			Value: "",
+			Usage: "Optional, A list of URLs to POST change events to as JSON.",
+		},
+		cli.StringFlag{
+			Name:   "webhooksecret",
+			Value:  "",
+			Usage:  "Optional, secret used to sign webhook deliveries",
+			EnvVar: "ROLLCALL_WEBHOOK_SECRET",
+		},
+		cli.StringFlag{
+			Name:  "webhookretries",
+			Value: "3",
+			Usage: "Optional, number of times a failed webhook delivery is retried",
+		},
+		cli.StringFlag{
+			Name:  "deadletter",
+			Value: "./webhook.deadletter",
+			Usage: "Optional, file that webhook deliveries which keep failing are saved to.",
 		},
 	}
+	app.Commands = []cli.Command{
+		updateDomainsCommand,
+		ackCommand,
+		configCommand,
+	}
 	app.Action = func(c *cli.Context) {
-		if c.String("apikey") == "" {
-			fmt.Printf("\n\nError: Slack API key must be set\n\n")
+		if err := configure(c); err != nil {
+			fmt.Printf("\n\nError: %v\n\n", err)
 
-			cli.ShowAppHelp(c)
+			if _, ok := err.(optionError); ok {
+				cli.ShowAppHelp(c)
+			}
 			return
 		}
 
-		apiKey = c.String("apikey")
+		if c.String("watch") == "" && c.String("listen") == "" && c.String("apptoken") == "" && c.String("serve") == "" {
+			err := rollCall(c.String("cache"), c.String("channelcache"))
+			checkDeadman()
+			if err != nil {
+				slog.Error("run failed", "error", err)
+				os.Exit(1)
+			}
+			return
+		}
 
-		isVerbose = false
+		// Every run compares against the previous one
+		saveCache = true
 
-		if c.String("verbose") == "true" {
-			isVerbose = true
+		memberCacheFile = c.String("cache")
+		channelCacheFile = c.String("channelcache")
+
+		if c.String("serve") != "" {
+			mux := http.NewServeMux()
+			if err := newDashboard(mux); err != nil {
+				fmt.Printf("\n\nError: %v\n\n", err)
+				return
+			}
+			if len(apiTokens) > 0 {
+				newAPI(mux)
+			}
+			mux.HandleFunc("/metrics", serveMetrics)
+			mux.HandleFunc("/healthz", serveHealthz)
+			mux.HandleFunc("/readyz", serveReadyz)
+			server, err := startServer(c.String("serve"), mux, "dashboard")
+			if err != nil {
+				fmt.Printf("\n\nError: %v\n\n", err)
+				os.Exit(1)
+			}
+			defer stopServer(server)
 		}
 
-		if c.String("updatecache") == "true" {
-			saveCache = true
+		if c.String("listen") != "" {
+			server, err := startReceiver(c.String("listen"), c.String("signingsecret"))
+			if err != nil {
+				fmt.Printf("\n\nError: %v\n\n", err)
+				os.Exit(1)
+			}
+			defer stopServer(server)
 		}
 
-		monitorString := c.String("monitor")
-		if monitorString != "" {
-			fmt.Printf("\nWill monitor the following domains:\n\t%s\n\n", monitorString)
-			monitored = strings.Split(monitorString, ",")
+		if c.String("apptoken") != "" {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go runSocketMode(ctx, c.String("apptoken"))
 		}
 
-		channel = c.String("channel")
+		if deadmanWindow > 0 {
+			stop := make(chan struct{})
+			defer close(stop)
+			go watchDeadman(stop)
+		}
+
+		schedule.Watch(runSchedule, func() error {
+			pipelineLock.Lock()
+			defer pipelineLock.Unlock()
 
-		dumpDelta(c.String("cache"))
+			if err := reloadAcks(); err != nil {
+				return err
+			}
+			return rollCall(c.String("cache"), c.String("channelcache"))
+		})
 	}
-	app.Run(os.Args)
+	return app
+}
+
+// optionError is a bad option, reported along with the usage
+type optionError string
+
+func (err optionError) Error() string {
+	return string(err)
 }
 
-func dumpDelta(fileName string) {
-	var hasChanges = false
+// configure applies the --config file and sets up everything the options
+// ask for without contacting Slack or starting anything, so a run and
+// "config validate" accept and reject the same options
+func configure(c *cli.Context) error {
+	if err := applyConfig(c); err != nil {
+		return optionError(err.Error())
+	}
+
+	notifiers = []Notifier{}
+
+	if c.String("apikey") == "" {
+		return optionError("Slack API key must be set")
+	}
+
+	apiKey = c.String("apikey")
+
+	isVerbose = false
+
+	if c.String("verbose") == "true" {
+		isVerbose = true
+	}
+
+	logLevel := c.String("loglevel")
+	if isVerbose {
+		logLevel = "debug"
+	}
+	logPII = c.String("logpii") == "true"
+	if err := logging.Setup(c.String("logformat"), logLevel); err != nil {
+		return optionError(err.Error())
+	}
+
+	if c.String("updatecache") == "true" {
+		saveCache = true
+	}
+
+	dryRun = c.String("dry-run") == "true"
+	if dryRun && (c.String("watch") != "" || c.String("listen") != "" || c.String("apptoken") != "" || c.String("serve") != "") {
+		return optionError("--dry-run runs once and cannot be combined with --watch, --listen, --apptoken or --serve")
+	}
+
+	monitorString := c.String("monitor")
+	if monitorString != "" {
+		slog.Info("monitoring", "rules", monitorString)
+		rules, err := parseMonitorRules(strings.Split(monitorString, ","))
+		if err != nil {
+			return err
+		}
+		monitored = rules
+	}
+
+	// Giving a list of domains turns its detection on
+	err := loadDomainLists(
+		c.String("disposable") == "true" || c.String("disposablelist") != "", options.List(c.String("disposablelist")),
+		c.String("freemail") == "true" || c.String("freemaillist") != "", options.List(c.String("freemaillist")))
+	if err != nil {
+		return err
+	}
+
+	lookalikeDomains = parseDomainList(c.String("lookalike"))
+	lookalikeDistance, err = strconv.Atoi(c.String("lookalikedistance"))
+	if err != nil || lookalikeDistance < 0 {
+		return optionError("--lookalikedistance must be a number of typos")
+	}
+
+	vipNames = options.List(c.String("vip"))
+	checkImpersonation = c.String("impersonation") == "true" || len(vipNames) > 0
+
+	riskScoring = c.String("risk") == "true"
+	riskThreshold, err = strconv.Atoi(c.String("riskthreshold"))
+	if err != nil || riskThreshold < 0 || riskThreshold > 100 {
+		return optionError("--riskthreshold must be a score from 0 to 100")
+	}
+
+	if c.String("acks") != "" {
+		ackFile = c.String("acks")
+		acks, err = loadAcks(ackFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	ackReviewers = options.List(c.String("ackreviewers"))
+	if err = checkAckReviewers(ackReviewers); err != nil {
+		return optionError("--ackreviewers: " + err.Error())
+	}
+
+	escalateTargets = options.List(c.String("escalate"))
+	for _, target := range escalateTargets {
+		if _, err = formatMention(target); err != nil {
+			return optionError(fmt.Sprintf("--escalate: %v", err))
+		}
+	}
+
+	historyFile = c.String("history")
+	raidWindow, err = time.ParseDuration(c.String("raidwindow"))
+	if err != nil || raidWindow <= 0 {
+		return optionError("--raidwindow must be a duration such as 15m")
+	}
+	raidThreshold, err = strconv.Atoi(c.String("raidthreshold"))
+	if err != nil || raidThreshold < 1 {
+		return optionError("--raidthreshold must be a number of members")
+	}
+	raidFactor, err = strconv.ParseFloat(c.String("raidfactor"), 64)
+	if err != nil || raidFactor < 0 {
+		return optionError("--raidfactor must be a number")
+	}
+
+	allowed = parseDomainList(c.String("allow"))
+	guestAllowed = parseDomainList(c.String("guestallow"))
+
+	if c.String("channel") != "" {
+		notifiers = append(notifiers, &SlackNotifier{
+			Channel:    c.String("channel"),
+			AckButtons: c.String("ackbuttons") == "true",
+		})
+	}
+
+	for _, url := range options.List(c.String("slackwebhook")) {
+		notifiers = append(notifiers, &SlackWebhookNotifier{url})
+	}
+
+	for _, url := range options.List(c.String("teams")) {
+		notifiers = append(notifiers, &TeamsNotifier{url})
+	}
+
+	for _, url := range options.List(c.String("discord")) {
+		notifiers = append(notifiers, &DiscordNotifier{url})
+	}
+
+	for _, url := range options.List(c.String("mattermost")) {
+		notifiers = append(notifiers, &MattermostNotifier{url})
+	}
+
+	if c.String("smtphost") != "" {
+		if c.String("emailfrom") == "" || len(options.List(c.String("emailto"))) == 0 {
+			return optionError("--emailfrom and --emailto must be set to send email")
+		}
+
+		notifiers = append(notifiers, &EmailNotifier{
+			Host:     c.String("smtphost"),
+			User:     c.String("smtpuser"),
+			Password: c.String("smtppassword"),
+			StartTLS: c.String("smtpstarttls"),
+			From:     c.String("emailfrom"),
+			To:       options.List(c.String("emailto")),
+		})
+	}
+
+	if c.String("webhook") != "" {
+		retries, err := strconv.Atoi(c.String("webhookretries"))
+		if err != nil || retries < 0 {
+			return optionError("--webhookretries must be a number of retries")
+		}
+
+		notifiers = append(notifiers, &WebhookNotifier{
+			URLs:       options.List(c.String("webhook")),
+			Secret:     c.String("webhooksecret"),
+			Retries:    retries,
+			DeadLetter: c.String("deadletter"),
+		})
+	}
+
+	routes := []*Route{}
+	if c.String("routes") != "" {
+		loaded, err := loadRoutes(c.String("routes"))
+		if err != nil {
+			return err
+		}
+		routes = loaded
+		for _, route := range routes {
+			notifiers = append(notifiers, route)
+		}
+	}
+
+	if c.String("rules") != "" {
+		rules, err := loadRules(c.String("rules"))
+		if err == nil {
+			err = checkRuleRoutes(rules, routes)
+		}
+		if err != nil {
+			return err
+		}
+		ruleSet = rules
+	}
+
+	if c.String("ignore") != "" {
+		ignorePrefixes = options.List(c.String("ignore"))
+		slog.Info("ignoring channels", "prefixes", ignorePrefixes)
+	}
+
+	eventLogFile = c.String("eventlog")
+
+	if c.String("deadman") != "" {
+		deadmanWindow, err = time.ParseDuration(c.String("deadman"))
+		if err != nil || deadmanWindow <= 0 {
+			return optionError("--deadman must be a duration such as 26h")
+		}
+	}
+
+	statusFile = c.String("status")
+	if err = loadHealth(); err != nil {
+		return err
+	}
+
+	spec := c.String("watch")
+	if spec == "" {
+		spec = defaultReconcileSchedule
+	}
+	if runSchedule, err = schedule.Parse(spec); err != nil {
+		return optionError(fmt.Sprintf("--watch: %v", err))
+	}
+
+	if c.String("listen") != "" && c.String("signingsecret") == "" {
+		return optionError("--signingsecret must be set to receive Slack events")
+	}
+
+	// The tokens protect the dashboard as well as the API
+	apiTokens = options.List(c.String("apitoken"))
+	if c.String("serve") != "" && len(apiTokens) == 0 && !isLoopbackAddress(c.String("serve")) {
+		return optionError("--apitoken must be set to serve the dashboard on " + c.String("serve") + ", or serve it on a loopback address such as 127.0.0.1:8081")
+	}
+
+	return nil
+}
+
+// rollCall reports the member changes, and the channel changes when a
+// channel cache is set, then delivers them to every configured destination.
+// The member cache is already updated when the channel check runs, so the
+// member changes are reported even when the channel check fails.
+func rollCall(memberCache string, channelCache string) error {
+	// The changes happened since the cached list was fetched
+	since := fileTime(memberCache)
+
+	events, err := dumpDelta(memberCache)
+	if recordCheck("members", err) != nil {
+		return err
+	}
+
+	var channelErr error
+	if channelCache != "" {
+		channelEvents, err := dumpChannelDelta(channelCache)
+		if channelErr = recordCheck("channels", err); channelErr == nil {
+			events = append(events, channelEvents...)
+		}
+	}
+
+	processEvents(events, channelCache != "" && channelErr == nil, since)
+	return channelErr
+}
+
+// processEvents acknowledges, detects raids, applies the rules and reports
+// the changes found by a full run or a Slack event, which happened since the
+// given time
+func processEvents(events []*Event, withChannels bool, since time.Time) {
+	events = filterAcknowledged(events)
+
+	if historyFile != "" {
+		events = detectRaid(events, since)
+	}
+
+	events = applyRules(ruleSet, events)
+
+	recordEvents(events)
+
+	if err := appendEventLog(events); err != nil {
+		slog.Error("event log", "file", eventLogFile, "error", err)
+	}
+
+	result := renderReport(events, withChannels)
+	fmt.Println(result)
+
+	if len(events) > 0 {
+		deliverReport(result, events)
+	}
+}
+
+// renderReport builds the text report from the events, grouped the same way
+// the lists are searched
+func renderReport(events []*Event, withChannels bool) string {
 	var result = ""
 
+	result = fmt.Sprintf("%sSearching for MIA\n", result)
+	result = renderEvents(result, events, EventMemberMissing, EventMemberDeleted, EventMemberRestored, EventRoleChanged, EventEmailChanged)
+
+	result = fmt.Sprintf("%sSearching for new members\n", result)
+	result = renderEvents(result, events, EventMemberJoined)
+
+	if hasEvents(events, EventMemberRaid) {
+		mention := escalationMention(filterEvents(events, EventMemberRaid))
+		result = fmt.Sprintf("%s\nSearching for join bursts %sWARNING possible raid in progress.\n", result, mention)
+		result = renderEvents(result, events, EventMemberRaid)
+	}
+
+	if hasEvents(events, EventRaidOver) {
+		result = fmt.Sprintf("%s\nSearching for join bursts, raid over.\n", result)
+		result = renderEvents(result, events, EventRaidOver)
+	}
+
+	if shouldEscalate(events) {
+		mention := escalationMention(filterEvents(events, EventSuspectMember))
+		result = fmt.Sprintf("%s\nSearching for monitored members %sWARNING possible bad actor(s) joined.\n*Please verify these users:*\n", result, mention)
+		result = renderEvents(result, events, EventSuspectMember)
+	} else if hasEvents(events, EventSuspectMember) {
+		result = fmt.Sprintf("%s\nSearching for monitored members, possible bad actor(s) joined.\n*Please verify these users:*\n", result)
+		result = renderEvents(result, events, EventSuspectMember)
+	}
+
+	if withChannels {
+		result = fmt.Sprintf("%sSearching for removed channels\n", result)
+		result = renderEvents(result, events, EventChannelRemoved, EventChannelArchived, EventChannelUnarchived, EventChannelRenamed)
+
+		result = fmt.Sprintf("%sSearching for new channels\n", result)
+		result = renderEvents(result, events, EventChannelCreated)
+	}
+
+	return result
+}
+
+func renderEvents(result string, events []*Event, eventTypes ...string) string {
+	for _, event := range events {
+		for _, eventType := range eventTypes {
+			if event.Type == eventType {
+				severity := ""
+				if event.Severity != "" {
+					severity = "[" + strings.ToUpper(event.Severity) + "] "
+				}
+				result = fmt.Sprintf("%s\t%s%s\n", result, severity, event.Text)
+			}
+		}
+	}
+	return result
+}
+
+func filterEvents(events []*Event, eventType string) []*Event {
+	filtered := []*Event{}
+	for _, event := range events {
+		if event.Type == eventType {
+			filtered = append(filtered, event)
+		}
+	}
+	return filtered
+}
+
+func hasEvents(events []*Event, eventType string) bool {
+	for _, event := range events {
+		if event.Type == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func dumpDelta(fileName string) ([]*Event, error) {
+	var events = []*Event{}
+
 	var previousList = loadMembersFromFile(fileName)
 	if previousList == nil {
-		result = fmt.Sprintf("%sNo member list cached. Will create one\n", result)
-		previousList := loadMemberList()
+		slog.Info("no member list cached, will create one", "file", fileName)
+		previousList, err := loadMemberList()
+		if err != nil {
+			return nil, err
+		}
 		json, _ := json.Marshal(previousList)
 		writeCache(fileName, json)
 
-		return
+		return events, nil
 	}
 
-	var currentList = loadMemberList()
-	if !currentList.Ok {
-		fmt.Printf("Current List Failed: \n%#v", currentList)
-		os.Exit(1)
+	currentList, err := loadMemberList()
+	if err != nil {
+		return nil, err
 	}
 
-	result = fmt.Sprintf("%sSearching for MIA\n", result)
+	events = diffMembers(previousList, currentList)
+
+	if saveCache {
+		slog.Info("updating member cache", "file", fileName)
+		//newMemberList := loadMemberList()
+		json, _ := json.Marshal(currentList)
+		//bytes.NewBuffer(json)
+		writeCache(fileName, json)
+	} else if dryRun {
+		showDryRun("member cache "+fileName+" would not be updated, -u is not true", nil)
+	}
+
+	return events, nil
+}
+
+// diffMembers returns the events for the changes between two member lists
+func diffMembers(previousList *MemberList, currentList *MemberList) []*Event {
+	var events = []*Event{}
+
+	identities := protectedIdentities(currentList)
+	previous := memberIndex(previousList)
+	current := memberIndex(currentList)
+
+	newMembers := 0
+	for _, element := range currentList.Members {
+		if previous[element.ID] == nil {
+			newMembers++
+		}
+	}
 
 	// Search for members who were in previous list
 	// and no longer exit in the current list
 	// or the deleted flag has changed
 	for _, previousRecord := range previousList.Members {
-		currentRecord := findMember(previousRecord.ID, currentList)
-		if currentRecord == nil {
-			hasChanges = true
-			title := ""
-			if len(previousRecord.Profile.Title) > 0 {
-				title = "\n\t\t      " + previousRecord.Profile.Title
-			}
-
-			isBot := ""
-			if previousRecord.IsBot {
-				isBot = " [BOT] "
-			}
+		events = append(events, diffMember(previousRecord, current[previousRecord.ID], identities, newMembers)...)
+	}
 
-			realName := previousRecord.RealName
-			if len(realName) == 0 {
-				realName = previousRecord.Profile.RealName
-				//om, _ := json.Marshal(previousRecord)
-				//fmt.Printf("%s\n", string(om))
-			}
+	// Search for new members
+	for _, element := range currentList.Members {
+		if previous[element.ID] == nil {
+			events = append(events, diffMember(nil, element, identities, newMembers)...)
+		}
+	}
 
-			result = fmt.Sprintf("%s\t--- Missing Member, %s, %s, %s, %s\n",
-				result,
-				realName,
-				previousRecord.Profile.Email,
-				isBot,
-				title)
-		} else if currentRecord.Deleted != previousRecord.Deleted {
-			isDelete := "No"
-
-			if currentRecord.Deleted {
-				isDelete = "Yes"
-			}
+	return events
+}
 
-			title := ""
-			if len(currentRecord.Profile.Title) > 0 {
-				title = "\n\t\t      " + currentRecord.Profile.Title
-			}
+// diffMember returns the events for the changes to a single member. A nil
+// previous record is a new member, a nil current record a missing one, and
+// newMembers is the number of members that joined alongside it
+func diffMember(previousRecord *User, currentRecord *User, identities []*ProtectedIdentity, newMembers int) []*Event {
+	var events = []*Event{}
+
+	if previousRecord == nil {
+		// Build a reliable name
+		var name = currentRecord.ID
+		if len(currentRecord.RealName) > 0 {
+			name = currentRecord.RealName
+		} else if len(currentRecord.Name) > 0 {
+			name = currentRecord.Name
+		}
 
-			isBot := ""
-			if currentRecord.IsBot {
-				isBot = " [BOT] "
-			}
+		var isBot = ""
+		if currentRecord.IsBot {
+			isBot = fmt.Sprintf(", isBot: YES, (%s)", currentRecord.Profile.BotId)
+		}
 
-			realName := currentRecord.RealName
-			if len(realName) == 0 {
-				realName = previousRecord.RealName
-			}
+		reasons := suspectReasons(currentRecord)
+		impersonation := impersonationReason(currentRecord, identities)
+		if impersonation != "" {
+			reasons = append(reasons, impersonation)
+		}
 
-			emailAddr := currentRecord.Profile.Email
-			if len(emailAddr) == 0 {
-				emailAddr = previousRecord.Profile.Email
-			}
+		score, signals := 0, []string{}
+		risk := ""
+		if riskScoring && !currentRecord.IsBot {
+			score, signals = riskScore(currentRecord, reasons, newMembers)
+			risk = fmt.Sprintf("(risk %d)", score)
+		}
 
-			hasChanges = true
-			result = fmt.Sprintf("%s\t--- Member, %s, %s, isDelete: %s %s %s\n",
-				result,
-				realName,
-				emailAddr,
-				isDelete,
-				isBot,
-				title)
-
-			if previousRecord != nil {
-				fmt.Printf("\n\nPrevious Record: %+v\n", previousRecord)
+		text := fmt.Sprintf("+++ New Member, %s, %s, %s - %s %s", name, currentRecord.Profile.Email, currentRecord.Profile.Title, isBot, risk)
+		joined := newMemberEvent(EventMemberJoined, currentRecord, nil, text)
+		joined.Score = score
+		events = append(events, joined)
+
+		if len(reasons) > 0 || (riskScoring && score >= riskThreshold) {
+			text := fmt.Sprintf("*** Suspect Member, %s, %s, %s - %s %s %s", name, currentRecord.Profile.Email, currentRecord.Profile.Title, isBot,
+				strings.Join(append(reasons, signals...), ", "), risk)
+			event := newMemberEvent(EventSuspectMember, currentRecord, nil, text)
+			event.Reasons = append(reasons, signals...)
+			event.Score = score
+			if riskScoring && score >= riskThreshold {
+				event.Severity = "high"
 			}
-
-			if currentRecord != nil {
-				fmt.Printf("Current Record: %+v\n\n", currentRecord)
+			if impersonation != "" {
+				event.Severity = "critical"
 			}
+			events = append(events, event)
 		}
+
+		return events
 	}
 
-	result = fmt.Sprintf("%sSearching for new members\n", result)
+	if currentRecord == nil {
+		title := ""
+		if len(previousRecord.Profile.Title) > 0 {
+			title = "\n\t\t      " + previousRecord.Profile.Title
+		}
 
-	// Search for new members
-	hasMonitoredEntries := false
-	monitoredEntries := "Searching for monitored members @everyone WARNING possible bad actor(s) joined.\n*Please verify these users:*\n"
+		isBot := ""
+		if previousRecord.IsBot {
+			isBot = " [BOT] "
+		}
 
-	for _, element := range currentList.Members {
-		previousRecord := findMember(element.ID, previousList)
-		if previousRecord == nil {
-			hasChanges = true
-
-			// Build a reliable name
-			var name = element.ID
-			if len(element.RealName) > 0 {
-				name = element.RealName
-			} else if len(element.Name) > 0 {
-				name = element.Name
-			}
+		realName := previousRecord.RealName
+		if len(realName) == 0 {
+			realName = previousRecord.Profile.RealName
+			//om, _ := json.Marshal(previousRecord)
+			//fmt.Printf("%s\n", string(om))
+		}
 
-			var isBot = ""
-			if element.IsBot {
-				isBot = fmt.Sprintf(", isBot: YES, (%s)", element.Profile.BotId)
-			}
+		text := fmt.Sprintf("--- Missing Member, %s, %s, %s, %s",
+			realName,
+			previousRecord.Profile.Email,
+			isBot,
+			title)
+		events = append(events, newMemberEvent(EventMemberMissing, nil, previousRecord, text))
+	} else if currentRecord.Deleted != previousRecord.Deleted {
+		isDelete := "No"
+
+		if currentRecord.Deleted {
+			isDelete = "Yes"
+		}
 
-			result = fmt.Sprintf("%s\t+++ New Member, %s, %s, %s - %s \n", result, name, element.Profile.Email, element.Profile.Title, isBot)
+		title := ""
+		if len(currentRecord.Profile.Title) > 0 {
+			title = "\n\t\t      " + currentRecord.Profile.Title
+		}
 
-			if isMonitored(element.Profile.Email) {
-				hasMonitoredEntries = true
-				monitoredEntries = fmt.Sprintf("%s\t*** Suspect Member, %s, %s, %s - %s \n", monitoredEntries, name, element.Profile.Email, element.Profile.Title, isBot)
-			}
+		isBot := ""
+		if currentRecord.IsBot {
+			isBot = " [BOT] "
+		}
+
+		realName := currentRecord.RealName
+		if len(realName) == 0 {
+			realName = previousRecord.RealName
+		}
+
+		emailAddr := currentRecord.Profile.Email
+		if len(emailAddr) == 0 {
+			emailAddr = previousRecord.Profile.Email
 		}
+
+		eventType := EventMemberRestored
+		if currentRecord.Deleted {
+			eventType = EventMemberDeleted
+		}
+
+		text := fmt.Sprintf("--- Member, %s, %s, isDelete: %s %s %s",
+			realName,
+			emailAddr,
+			isDelete,
+			isBot,
+			title)
+		events = append(events, newMemberEvent(eventType, currentRecord, previousRecord, text))
+
+		slog.Debug("member deleted state changed", userAttr("previous", previousRecord), userAttr("current", currentRecord))
 	}
 
-	if hasMonitoredEntries {
-		result = fmt.Sprintf("%s\n%s", result, monitoredEntries)
+	if currentRecord != nil && memberRole(currentRecord) != memberRole(previousRecord) {
+		realName := currentRecord.RealName
+		if len(realName) == 0 {
+			realName = currentRecord.Name
+		}
+
+		text := fmt.Sprintf("*** Role Changed, %s, %s, %s -> %s",
+			realName,
+			currentRecord.Profile.Email,
+			memberRole(previousRecord),
+			memberRole(currentRecord))
+		events = append(events, newMemberEvent(EventRoleChanged, currentRecord, previousRecord, text))
 	}
 
-	if saveCache {
-		fmt.Println("Updating cache")
-		//newMemberList := loadMemberList()
-		json, _ := json.Marshal(currentList)
-		//bytes.NewBuffer(json)
-		writeCache(fileName, json)
+	if currentRecord != nil && len(previousRecord.Profile.Email) > 0 && len(currentRecord.Profile.Email) > 0 &&
+		!strings.EqualFold(currentRecord.Profile.Email, previousRecord.Profile.Email) {
+		text := fmt.Sprintf("*** Email Changed, %s, %s -> %s",
+			displayName(currentRecord),
+			previousRecord.Profile.Email,
+			currentRecord.Profile.Email)
+		events = append(events, newMemberEvent(EventEmailChanged, currentRecord, previousRecord, text))
+
+		reasons := suspectReasons(currentRecord)
+		if len(reasons) > 0 {
+			text := fmt.Sprintf("*** Suspect Member, %s, %s, %s - email changed, %s",
+				displayName(currentRecord),
+				currentRecord.Profile.Email,
+				currentRecord.Profile.Title,
+				strings.Join(reasons, ", "))
+			event := newMemberEvent(EventSuspectMember, currentRecord, previousRecord, text)
+			event.Reasons = reasons
+			events = append(events, event)
+		}
 	}
 
-	fmt.Println(result)
+	if currentRecord != nil && !currentRecord.Deleted &&
+		!sameNames(comparableNames(displayName(currentRecord)), comparableNames(displayName(previousRecord))) {
+		if reason := impersonationReason(currentRecord, identities); reason != "" {
+			text := fmt.Sprintf("*** Suspect Member, %s, %s, %s - renamed from %s, %s",
+				displayName(currentRecord),
+				currentRecord.Profile.Email,
+				currentRecord.Profile.Title,
+				displayName(previousRecord),
+				reason)
+			event := newMemberEvent(EventSuspectMember, currentRecord, previousRecord, text)
+			event.Reasons = []string{reason}
+			event.Severity = "critical"
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
 
-	if channel != "" && hasChanges {
-		postMessage(channel, result)
+// memberIndex maps each member of the list by ID
+func memberIndex(members *MemberList) map[string]*User {
+	index := make(map[string]*User, len(members.Members))
+	for _, member := range members.Members {
+		index[member.ID] = member
 	}
+	return index
+}
+
+func deliverReport(result string, events []*Event) {
+	notifyAll(notifiers, &Report{
+		Title:  "Slack Roll Call: membership changes",
+		Text:   result,
+		Events: events,
+	})
+}
+
+func sameNames(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func memberRole(user *User) string {
+	if user.IsPrimaryOwner {
+		return "Primary Owner"
+	} else if user.IsOwner {
+		return "Owner"
+	} else if user.IsAdmin {
+		return "Admin"
+	}
+	return "Member"
 }
 
 func loadMembersFromFile(fileName string) *MemberList {
 
 	file, e := ioutil.ReadFile(fileName)
 	if e != nil {
-		fmt.Printf("File error: %v\n", e)
+		logging.ReadError(e)
 		return nil
 	}
 
@@ -341,11 +1169,32 @@ func findMember(id string, members *MemberList) *User {
 	return nil
 }
 
-func isMonitored(id string) bool {
-	fmt.Printf("Checking: %s\n", id)
-	for _, element := range monitored {
-		if caseInsensitiveContains(id, element) {
-			fmt.Printf("%s matched monitored domain %s\n", id, element)
+// suspectReasons lists why a new or changed member should be verified
+func suspectReasons(user *User) []string {
+	reasons := []string{}
+	if isMonitored(user) {
+		reasons = append(reasons, "monitored")
+	}
+	if !isAllowed(user) {
+		reasons = append(reasons, "unapproved domain")
+	}
+	if !user.IsBot && isDisposable(user) {
+		reasons = append(reasons, "disposable email")
+	}
+	if !user.IsBot && isFreemail(user) {
+		reasons = append(reasons, "free email provider")
+	}
+	if reason := lookalikeReason(user.Profile.Email); reason != "" {
+		reasons = append(reasons, reason)
+	}
+	return reasons
+}
+
+func isMonitored(user *User) bool {
+	slog.Debug("checking member", "id", user.ID, "email", redactEmail(user.Profile.Email))
+	for _, rule := range monitored {
+		if rule.matches(user) {
+			slog.Info("matched monitor rule", "id", user.ID, "email", redactEmail(user.Profile.Email), "rule", rule.Rule)
 			return true
 		}
 	}
@@ -357,127 +1206,134 @@ func caseInsensitiveContains(s, substr string) bool {
 	return strings.Contains(s, substr)
 }
 
-func loadMemberListAsJson(cursor string) []byte {
-	url := "https://slack.com/api/users.list?pretty=1"
+func loadMemberListAsJson(cursor string) ([]byte, error) {
+	url := slackAPIURL + "users.list?pretty=1"
 
 	if len(cursor) > 0 {
 		url = url + "&cursor=" + cursor
 	}
-	fmt.Printf("UserList URL: %s\n", url)
+	slog.Debug("loading users", "url", url)
 
 	client := &http.Client{}
 	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Add("Authorization", "Bearer "+apiKey)
 	response, err := client.Do(req)
-
 	if err != nil {
-		fmt.Printf("%s", err)
-		os.Exit(1)
-	} else {
-		defer response.Body.Close()
-		contents, err := ioutil.ReadAll(response.Body)
-		if err != nil {
-			fmt.Printf("%s", err)
-			os.Exit(1)
-		}
-
-		return contents
+		return nil, err
 	}
+	defer response.Body.Close()
 
-	return nil
+	return ioutil.ReadAll(response.Body)
 }
 
-func loadMemberList() *MemberList {
+func loadMemberList() (*MemberList, error) {
+	start := time.Now()
+	members, pages, err := loadMemberPages()
+	recordMemberFetch(time.Since(start), pages, err)
+	return members, err
+}
+
+// loadMemberPages loads every page of users.list, returning the number of pages read
+func loadMemberPages() (*MemberList, int, error) {
 	pageNum := 1
 	var cursor = ""
 
-	var currentList = loadMemberListForCursor(cursor)
-	if !currentList.Ok {
-		fmt.Printf("Current List Failed: \n%#v", currentList)
-		os.Exit(1)
+	currentList, err := loadMemberListForCursor(cursor)
+	if err != nil {
+		return nil, pageNum, err
+	}
+	if currentList == nil || !currentList.Ok {
+		return nil, pageNum, fmt.Errorf("current list failed: %#v", currentList)
 	}
 
 	cursor = currentList.Metadata.NextCursor
-	fmt.Printf("\tNext Cursor: %s\n", cursor)
+	slog.Debug("loaded users page", "page", pageNum, "next_cursor", cursor)
 
 	for len(cursor) > 0 {
 		pageNum = pageNum + 1
-		var nextPage = loadMemberListForCursor(cursor)
+		nextPage, err := loadMemberListForCursor(cursor)
+		if err != nil {
+			return nil, pageNum, err
+		}
 		if nextPage == nil || !nextPage.Ok {
-			fmt.Printf("Failed to load member list from server: \n%#v\n", nextPage)
-			os.Exit(1)
+			return nil, pageNum, fmt.Errorf("failed to load member list from server: %#v", nextPage)
 		}
 
 		currentList.Members = append(currentList.Members, nextPage.Members...)
 		cursor = nextPage.Metadata.NextCursor
-		fmt.Printf("\t%d, Next Cursor: %s\n", pageNum, cursor)
+		slog.Debug("loaded users page", "page", pageNum, "next_cursor", cursor)
 	}
 
-	return currentList
+	return currentList, pageNum, nil
 }
 
-func loadMemberListForCursor(cursor string) *MemberList {
-	contents := loadMemberListAsJson(cursor)
-
-	if contents != nil {
-		var members *MemberList
-		json.Unmarshal(contents, &members)
-		return members
+func loadMemberListForCursor(cursor string) (*MemberList, error) {
+	contents, err := loadMemberListAsJson(cursor)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	var members *MemberList
+	json.Unmarshal(contents, &members)
+	return members, nil
 }
 
-func writeCache(filename string, byteArray []byte) {
-
-	json := string(byteArray)
-
-	fmt.Println("writing: " + filename)
-	f, err := os.Create(filename)
+// fileTime returns when the file was last written, zero when it does not exist
+func fileTime(fileName string) time.Time {
+	info, err := os.Stat(fileName)
 	if err != nil {
-		fmt.Println(err)
+		return time.Time{}
 	}
-	n, err := io.WriteString(f, json)
-	if err != nil {
-		fmt.Println(n, err)
+	return info.ModTime()
+}
+
+func writeCache(filename string, byteArray []byte) {
+	if dryRun {
+		showDryRun(fmt.Sprintf("would write %s (%d bytes)", filename, len(byteArray)), nil)
+		return
+	}
+
+	slog.Debug("writing", "file", filename)
+	if err := cache.Write(filename, byteArray); err != nil {
+		slog.Error("write failed", "file", filename, "error", err)
 	}
-	f.Close()
 }
 
-func postMessage(channel string, message string) []byte {
+func postMessage(channel string, message string) ([]byte, error) {
+	return postMessageWithBlocks(channel, message, nil)
+}
+
+func postMessageWithBlocks(channel string, message string, blocks []interface{}) ([]byte, error) {
 	slackMessage := &SlackMessage{
 		channel,
 		message,
+		blocks,
 	}
 	json, err := json.Marshal(slackMessage)
 	//fmt.Printf("POST: %s\n\n", json)
 
-	url := "https://slack.com/api/chat.postMessage"
+	if dryRun {
+		showDryRun("would post to "+channel+" with chat.postMessage", json)
+		return []byte(`{"ok":true}`), nil
+	}
+
+	url := slackAPIURL + "chat.postMessage"
 	client := &http.Client{}
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(json))
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Add("Authorization", "Bearer "+apiKey)
 	req.Header.Add("Content-type", "application/json; charset=utf-8")
 	//req.Header.Add("Content-Type", "text/html; charset=utf-8")
 	response, err := client.Do(req)
-
 	if err != nil {
-		fmt.Printf("%s", err)
-		os.Exit(1)
-	} else {
-		defer response.Body.Close()
-		contents, err := ioutil.ReadAll(response.Body)
-
-		if err != nil {
-			fmt.Printf("%s", err)
-			os.Exit(1)
-		} /*
-			else {
-				fmt.Println(string(contents))
-			}
-		*/
-
-		return contents
+		return nil, err
 	}
+	defer response.Body.Close()
 
-	return nil
+	return ioutil.ReadAll(response.Body)
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yepher/SlackRollCall/conversations"
+)
+
+// newSlackStandIn serves the Web API methods from a local server for the
+// rest of the test
+func newSlackStandIn(t *testing.T, methods map[string]interface{}) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := methods[strings.TrimPrefix(r.URL.Path, "/api/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+
+	previousSlack, previousConversations := slackAPIURL, conversations.APIURL
+	slackAPIURL = server.URL + "/api/"
+	conversations.APIURL = server.URL + "/api/"
+	t.Cleanup(func() {
+		server.Close()
+		slackAPIURL, conversations.APIURL = previousSlack, previousConversations
+	})
+}
+
+func writeTestCache(t *testing.T, fileName string, value interface{}) {
+	contents, _ := json.Marshal(value)
+	if err := ioutil.WriteFile(fileName, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testUser(id string, name string, email string) *User {
+	user := &User{ID: id, Name: name, RealName: name}
+	user.Profile.Email = email
+	return user
+}
+
+func TestRollCallReportsMembersWhenChannelsFail(t *testing.T) {
+	dir := t.TempDir()
+	memberCache := filepath.Join(dir, "userList.cache")
+	channelCache := filepath.Join(dir, "channelList.cache")
+
+	alice := testUser("U1", "alice", "alice@example.com")
+	bob := testUser("U2", "bob", "bob@example.com")
+	writeTestCache(t, memberCache, &MemberList{Ok: true, Members: []*User{alice}})
+	writeTestCache(t, channelCache, &conversations.List{Ok: true})
+
+	newSlackStandIn(t, map[string]interface{}{
+		"users.list":         &MemberList{Ok: true, Members: []*User{alice, bob}},
+		"conversations.list": map[string]interface{}{"ok": false, "error": "ratelimited"},
+	})
+
+	recorder := &reportRecorder{}
+	notifiers = []Notifier{recorder}
+	saveCache = true
+	t.Cleanup(func() {
+		notifiers = []Notifier{}
+		saveCache = false
+	})
+
+	if err := rollCall(memberCache, channelCache); err == nil {
+		t.Fatal("the channel failure was not returned")
+	}
+
+	if len(recorder.reports) != 1 {
+		t.Fatalf("got %d reports, want the member changes", len(recorder.reports))
+	}
+	joined := false
+	for _, event := range recorder.reports[0].Events {
+		joined = joined || (event.Type == EventMemberJoined && event.User.ID == "U2")
+	}
+	if !joined {
+		t.Fatalf("the join was not reported: %#v", recorder.reports[0].Events)
+	}
+
+	if status := health.Checks["channels"]; status == nil || status.LastError == "" {
+		t.Fatal("the channel failure was not recorded")
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/**
+Socket Mode: https://api.slack.com/apis/connections/socket
+	For deployments that can't expose --listen to Slack. Enable Socket Mode
+	in the Slack app, create an app-level token with connections:write and
+	pass it with --apptoken: SlackRollCall opens a WebSocket to Slack with
+	apps.connections.open and receives the same events, and the Acknowledge
+	button clicks, over it. Every envelope is acknowledged as it arrives and
+	the connection is reopened whenever Slack asks for it or it drops. Slack
+	pings the connection regularly, so one that stays silent for
+	socketModeReadTimeout is taken as dropped.
+**/
+
+var socketModeOpenURL = "https://slack.com/api/apps.connections.open"
+
+// socketModeMaxBackoff caps the wait between reconnection attempts
+const socketModeMaxBackoff = 30 * time.Second
+
+// socketModeReadTimeout is how long the connection may go without a message
+// or a ping before it is reopened
+var socketModeReadTimeout = 2 * time.Minute
+
+// SocketModeEnvelope is a message received over the Socket Mode connection
+type SocketModeEnvelope struct {
+	Type       string          `json:"type"`
+	EnvelopeID string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload"`
+	Reason     string          `json:"reason"`
+}
+
+// SocketModeAck acknowledges an envelope
+type SocketModeAck struct {
+	EnvelopeID string `json:"envelope_id"`
+}
+
+// SocketModeConnection is the apps.connections.open response
+type SocketModeConnection struct {
+	SlackResponse
+	URL string `json:"url"`
+}
+
+// runSocketMode receives events over Socket Mode until the context is done
+func runSocketMode(ctx context.Context, appToken string) {
+	events := newSlackEventsHandler("")
+	backoff := time.Second
+
+	for {
+		started := time.Now()
+		err := receiveSocketMode(ctx, appToken, events)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Slack asked to reconnect, or the connection lasted a while
+		if err == nil || time.Since(started) > socketModeMaxBackoff {
+			backoff = time.Second
+		}
+		if err == nil {
+			continue
+		}
+		slog.Warn("Socket Mode disconnected", "error", err, "reconnect_in", backoff.String())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > socketModeMaxBackoff {
+			backoff = socketModeMaxBackoff
+		}
+	}
+}
+
+// receiveSocketMode reads one connection until Slack asks to reconnect, the
+// connection drops or the context is done
+func receiveSocketMode(ctx context.Context, appToken string, events *slackEventsHandler) error {
+	url, err := openSocketMode(appToken)
+	if err != nil {
+		return err
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			conn.Close()
+		case <-closed:
+		}
+	}()
+
+	conn.SetPingHandler(func(message string) error {
+		conn.SetReadDeadline(time.Now().Add(socketModeReadTimeout))
+		err := conn.WriteControl(websocket.PongMessage, []byte(message), time.Now().Add(time.Second))
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		return err
+	})
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(socketModeReadTimeout))
+
+		var envelope SocketModeEnvelope
+		if err = conn.ReadJSON(&envelope); err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				slog.Warn("Socket Mode connection went quiet, reconnecting", "timeout", socketModeReadTimeout.String())
+				return nil
+			}
+			return err
+		}
+
+		if envelope.EnvelopeID != "" {
+			if err = conn.WriteJSON(&SocketModeAck{envelope.EnvelopeID}); err != nil {
+				return err
+			}
+		}
+
+		switch envelope.Type {
+		case "hello":
+			slog.Info("connected to Slack with Socket Mode")
+		case "disconnect":
+			slog.Info("Slack asked to reconnect", "reason", envelope.Reason)
+			return nil
+		case "events_api":
+			var event SlackEventEnvelope
+			if err = json.Unmarshal(envelope.Payload, &event); err == nil {
+				err = events.receive(&event)
+			}
+			if err != nil {
+				slog.Error("handling Slack event", "error", err)
+			}
+		case "interactive":
+			if ackFile == "" {
+				continue
+			}
+			var interaction SlackInteraction
+			if err = json.Unmarshal(envelope.Payload, &interaction); err == nil {
+				err = recordAckInteraction(ackFile, "", ackReviewers, &interaction)
+			}
+			if err != nil {
+				slog.Error("recording acknowledgement", "error", err)
+			}
+		}
+	}
+}
+
+// openSocketMode asks Slack for a Socket Mode WebSocket URL
+func openSocketMode(appToken string) (string, error) {
+	req, err := http.NewRequest("POST", socketModeOpenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Add("Authorization", "Bearer "+appToken)
+	req.Header.Add("Content-type", "application/x-www-form-urlencoded")
+
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	contents, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var connection SocketModeConnection
+	if err = json.Unmarshal(contents, &connection); err != nil {
+		return "", err
+	}
+	if !connection.Ok {
+		return "", fmt.Errorf("apps.connections.open: %s", connection.Error)
+	}
+
+	return connection.URL, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newSocketModeStandIn serves apps.connections.open and the WebSocket it
+// hands out. Every connection is passed to the handler in turn.
+func newSocketModeStandIn(t *testing.T, appToken string, handle func(n int, conn *websocket.Conn)) {
+	upgrader := websocket.Upgrader{}
+	connections := 0
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/apps.connections.open" {
+			if r.Method != "POST" || r.Header.Get("Authorization") != "Bearer "+appToken {
+				fmt.Fprint(w, `{"ok":false,"error":"invalid_auth"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"ok":true,"url":"ws%s/link"}`, strings.TrimPrefix(server.URL, "http"))
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		connections++
+		handle(connections, conn)
+	}))
+
+	previous := socketModeOpenURL
+	socketModeOpenURL = server.URL + "/apps.connections.open"
+	t.Cleanup(func() {
+		server.Close()
+		socketModeOpenURL = previous
+	})
+}
+
+func TestSocketMode(t *testing.T) {
+	memberCacheFile = filepath.Join(t.TempDir(), "userList.cache")
+	writeTestCache(t, memberCacheFile, &MemberList{Ok: true, Members: []*User{testUser("U1", "alice", "alice@example.com")}})
+
+	recorder := &reportRecorder{}
+	notifiers = []Notifier{recorder}
+	t.Cleanup(func() {
+		memberCacheFile = ""
+		notifiers = []Notifier{}
+	})
+
+	acked := make(chan string, 1)
+	reconnected := make(chan struct{})
+	newSocketModeStandIn(t, "xapp-1", func(n int, conn *websocket.Conn) {
+		conn.WriteJSON(&SocketModeEnvelope{Type: "hello"})
+		if n > 1 {
+			close(reconnected)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}
+
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"events_api","envelope_id":"env-1","payload":`+
+			`{"type":"event_callback","event_id":"Ev1","event":{"type":"team_join","user":{"id":"U2","name":"bob"}}}}`))
+		var ack SocketModeAck
+		conn.ReadJSON(&ack)
+		acked <- ack.EnvelopeID
+		conn.WriteJSON(&SocketModeEnvelope{Type: "disconnect", Reason: "refresh_requested"})
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		runSocketMode(ctx, "xapp-1")
+		close(stopped)
+	}()
+
+	if id := <-acked; id != "env-1" {
+		t.Fatalf("got ack for %q, want env-1", id)
+	}
+
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not reconnect when Slack asked to")
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not stop with the context")
+	}
+
+	// The event is handled on the events queue
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pipelineLock.Lock()
+		reports := len(recorder.reports)
+		pipelineLock.Unlock()
+		if reports > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the event was not reported")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	pipelineLock.Lock()
+	defer pipelineLock.Unlock()
+	if event := recorder.reports[0].Events[0]; event.Type != EventMemberJoined || event.User.ID != "U2" {
+		t.Fatalf("got %s for %v", event.Type, event.User)
+	}
+}
+
+func TestOpenSocketModeRejected(t *testing.T) {
+	newSocketModeStandIn(t, "xapp-1", func(n int, conn *websocket.Conn) {})
+
+	if _, err := openSocketMode("xapp-wrong"); err == nil || !strings.Contains(err.Error(), "invalid_auth") {
+		t.Fatalf("got %v, want invalid_auth", err)
+	}
+}
+
+func TestSocketModeReconnectsWhenQuiet(t *testing.T) {
+	socketModeReadTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { socketModeReadTimeout = 2 * time.Minute })
+
+	pinged := make(chan struct{})
+	reconnected := make(chan bool, 1)
+	newSocketModeStandIn(t, "xapp-1", func(n int, conn *websocket.Conn) {
+		if n > 1 {
+			select {
+			case <-pinged:
+				reconnected <- true
+			default:
+				reconnected <- false
+			}
+			return
+		}
+
+		conn.WriteJSON(&SocketModeEnvelope{Type: "hello"})
+		read := make(chan struct{})
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					close(read)
+					return
+				}
+			}
+		}()
+
+		// Pings keep a connection without envelopes open
+		for i := 0; i < 10; i++ {
+			conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+			time.Sleep(50 * time.Millisecond)
+		}
+		close(pinged)
+		<-read
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		runSocketMode(ctx, "xapp-1")
+		close(stopped)
+	}()
+	// The timeout is put back only once the connection is closed
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	select {
+	case afterPings := <-reconnected:
+		if !afterPings {
+			t.Fatal("reconnected while Slack was pinging")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not reconnect when the connection went quiet")
+	}
+}
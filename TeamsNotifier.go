@@ -0,0 +1,64 @@
+package main
+
+/**
+Teams Connector: https://learn.microsoft.com/microsoftteams/platform/webhooks-and-connectors/how-to/connectors-using
+	Example: https://example.webhook.office.com/webhookb2/...
+
+	Each event becomes a MessageCard section listing its details as facts.
+**/
+
+// TeamsNotifier posts the report to a Microsoft Teams incoming webhook connector
+type TeamsNotifier struct {
+	URL string
+}
+
+// TeamsMessageCard is the legacy actionable message card accepted by Teams connectors
+type TeamsMessageCard struct {
+	Type       string          `json:"@type"`
+	Context    string          `json:"@context"`
+	Summary    string          `json:"summary"`
+	ThemeColor string          `json:"themeColor"`
+	Title      string          `json:"title"`
+	Sections   []*TeamsSection `json:"sections"`
+}
+
+// TeamsSection describes a single event in a TeamsMessageCard
+type TeamsSection struct {
+	ActivityTitle string       `json:"activityTitle"`
+	Facts         []*TeamsFact `json:"facts"`
+}
+
+// TeamsFact is a name/value row in a TeamsSection
+type TeamsFact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Name identifies the notifier in error messages
+func (notifier *TeamsNotifier) Name() string {
+	return "Teams"
+}
+
+// Notify posts a message card with a section per event
+func (notifier *TeamsNotifier) Notify(report *Report) error {
+	card := &TeamsMessageCard{
+		Type:       "MessageCard",
+		Context:    "https://schema.org/extensions",
+		Summary:    report.Title,
+		ThemeColor: "4A154B",
+		Title:      report.Title,
+	}
+
+	for _, event := range report.Events {
+		section := &TeamsSection{
+			ActivityTitle: eventTitle(event),
+		}
+		for _, field := range eventFields(event) {
+			section.Facts = append(section.Facts, &TeamsFact{field.Name, field.Value})
+		}
+		card.Sections = append(card.Sections, section)
+	}
+
+	_, err := postJSON(notifier.URL, card)
+	return err
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTeamsNotifier(t *testing.T) {
+	url, payloads := recordPayloads(t)
+
+	notifier := &TeamsNotifier{url}
+	if err := notifier.Notify(&Report{Title: "Roll Call", Events: testEvents()}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*payloads) != 1 {
+		t.Fatalf("%d cards posted, want one", len(*payloads))
+	}
+
+	card := &TeamsMessageCard{}
+	if err := json.Unmarshal((*payloads)[0], card); err != nil {
+		t.Fatal(err)
+	}
+	if card.Type != "MessageCard" || card.Title != "Roll Call" || card.Summary != "Roll Call" || len(card.Sections) != 4 {
+		t.Fatalf("got card %q %q with %d sections", card.Type, card.Title, len(card.Sections))
+	}
+
+	tests := []struct {
+		title string
+		facts map[string]string
+	}{
+		{"New Member", map[string]string{"Name": "ann", "Email": "ann@example.com", "Title": "Engineer"}},
+		{"Missing Member", map[string]string{"Name": "bob", "Email": "bob@example.com"}},
+		{"Role Changed", map[string]string{"Name": "ann", "Role": "Member -> Admin"}},
+		{"Added Channel", map[string]string{"Channel": "#general"}},
+	}
+
+	for i, test := range tests {
+		section := card.Sections[i]
+		if section.ActivityTitle != test.title {
+			t.Errorf("section %d: got %q, want %q", i, section.ActivityTitle, test.title)
+		}
+		facts := map[string]string{}
+		for _, fact := range section.Facts {
+			facts[fact.Name] = fact.Value
+		}
+		for name, value := range test.facts {
+			if facts[name] != value {
+				t.Errorf("%s: %s is %q, want %q", test.title, name, facts[name], value)
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+/**
+Outgoing webhook delivery of change events.
+
+	Each URL receives a JSON WebhookPayload. When a secret is configured the
+	request carries these headers so receivers can verify the delivery:
+
+		X-RollCall-Timestamp: <unix seconds>
+		X-RollCall-Signature: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">
+
+	Failed deliveries are retried with exponential backoff, for at most a
+	minute in all so a dead receiver does not hold up the run. Deliveries that
+	keep failing are appended to the dead-letter file, one JSON object per line.
+**/
+
+var webhookBackoff = time.Second
+var webhookRetryWindow = time.Minute
+
+// WebhookNotifier POSTs the change events to outgoing webhooks
+type WebhookNotifier struct {
+	URLs       []string
+	Secret     string
+	Retries    int
+	DeadLetter string
+}
+
+// WebhookPayload is the JSON document POSTed to each webhook URL
+type WebhookPayload struct {
+	Source string    `json:"source"`
+	Time   time.Time `json:"time"`
+	Events []*Event  `json:"events"`
+}
+
+// DeadLetter records a webhook delivery that could not be completed
+type DeadLetter struct {
+	Time    time.Time       `json:"time"`
+	URL     string          `json:"url"`
+	Error   string          `json:"error"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Name identifies the notifier in error messages
+func (notifier *WebhookNotifier) Name() string {
+	return "Webhook"
+}
+
+// Notify delivers the report events to every webhook URL
+func (notifier *WebhookNotifier) Notify(report *Report) error {
+	return sendWebhooks(notifier, report.Events)
+}
+
+// sendWebhooks returns an error when any delivery ended in the dead-letter file
+func sendWebhooks(settings *WebhookNotifier, events []*Event) error {
+	body, err := json.Marshal(&WebhookPayload{
+		Source: "SlackRollCall",
+		Time:   time.Now().UTC(),
+		Events: events,
+	})
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	deadline := time.Now().Add(webhookRetryWindow)
+	for _, url := range settings.URLs {
+		err := deliverWebhook(settings, url, body, deadline)
+		if err != nil {
+			slog.Error("webhook delivery failed", "url", url, "error", err)
+			writeDeadLetter(settings.DeadLetter, url, body, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d webhook deliveries failed, saved to %s", failed, len(settings.URLs), settings.DeadLetter)
+	}
+	return nil
+}
+
+// deliverWebhook retries a failed delivery while the next attempt would
+// start before the deadline
+func deliverWebhook(settings *WebhookNotifier, url string, body []byte, deadline time.Time) error {
+	backoff := webhookBackoff
+
+	for attempt := 0; ; attempt++ {
+		retry, err := postWebhook(settings, url, body)
+		if err == nil || !retry || attempt >= settings.Retries {
+			return err
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("%v, gave up retrying after %s", err, webhookRetryWindow)
+		}
+
+		slog.Warn("webhook failed, retrying", "url", url, "error", err, "retry_in", backoff.String())
+		time.Sleep(backoff)
+		backoff = backoff * 2
+	}
+}
+
+// postWebhook makes a single delivery attempt. The returned bool reports
+// whether a failure is worth retrying.
+func postWebhook(settings *WebhookNotifier, url string, body []byte) (bool, error) {
+	if dryRun {
+		showDryRun("would post to "+dryRunHost(url), body)
+		return false, nil
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Add("Content-type", "application/json; charset=utf-8")
+	req.Header.Add("X-RollCall-Timestamp", timestamp)
+	if settings.Secret != "" {
+		req.Header.Add("X-RollCall-Signature", signWebhook(settings.Secret, timestamp, body))
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("unexpected response %s", response.Status)
+}
+
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func writeDeadLetter(fileName string, url string, body []byte, deliveryErr error) {
+	record, err := json.Marshal(&DeadLetter{
+		Time:    time.Now().UTC(),
+		URL:     url,
+		Error:   deliveryErr.Error(),
+		Payload: body,
+	})
+	if err != nil {
+		slog.Error("dead letter", "file", fileName, "error", err)
+		return
+	}
+
+	f, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		slog.Error("dead letter", "file", fileName, "error", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err = f.Write(append(record, '\n')); err != nil {
+		slog.Error("dead letter", "file", fileName, "error", err)
+	}
+}
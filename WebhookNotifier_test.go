@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignWebhook(t *testing.T) {
+	tests := []struct {
+		secret    string
+		timestamp string
+		body      string
+		signature string
+	}{
+		{"whsec", "1700000000", `{"events":[]}`, "sha256=d5cebb38cfa8b6704bfba53eb3c4d44ecd08c1f9a9944337dc4ddfed63383ee1"},
+		{"whsec", "1700000001", `{"events":[]}`, "sha256=8ccf38484018c91158f28f76347e9807ca80b5e0301f34f90d71d0558bf8ea0b"},
+		{"other", "1700000000", `{"events":[]}`, "sha256=75f1cd1d25a3805e6e9fd5050c9a7e72cf1ea63602a7256bad3e3e77acc6db4d"},
+		{"whsec", "1700000000", `{"events":[{}]}`, "sha256=0594028f970716c1c061b478321b7eda31df1a2c8059e64c646e70aae8cbb652"},
+	}
+
+	for _, test := range tests {
+		if signature := signWebhook(test.secret, test.timestamp, []byte(test.body)); signature != test.signature {
+			t.Errorf("signWebhook(%q, %q, %q) = %s, want %s", test.secret, test.timestamp, test.body, signature, test.signature)
+		}
+	}
+}
+
+// verifyWebhook checks a delivery the way a receiver following the
+// documentation would
+func verifyWebhook(secret string, r *http.Request, body []byte) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(r.Header.Get("X-RollCall-Timestamp") + "." + string(body)))
+	return hmac.Equal([]byte("sha256="+hex.EncodeToString(mac.Sum(nil))), []byte(r.Header.Get("X-RollCall-Signature")))
+}
+
+func TestSendWebhooks(t *testing.T) {
+	attempts := 0
+	verified := 0
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if verifyWebhook("whsec", r, body) {
+			verified++
+		}
+		if attempts++; attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer flaky.Close()
+
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+
+	deadLetter := filepath.Join(t.TempDir(), "webhook.deadletter")
+	notifier := &WebhookNotifier{
+		URLs:       []string{flaky.URL, rejecting.URL},
+		Secret:     "whsec",
+		Retries:    1,
+		DeadLetter: deadLetter,
+	}
+	err := notifier.Notify(&Report{Events: []*Event{newMemberEvent(EventMemberJoined, testUser("U1", "ann", "ann@example.com"), nil, "")}})
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 webhook deliveries failed") {
+		t.Fatalf("got %v, want the dead-lettered delivery reported", err)
+	}
+
+	if attempts != 2 || verified != 2 {
+		t.Fatalf("got %d attempts with %d valid signatures, want a signed retry", attempts, verified)
+	}
+
+	file, err := os.Open(deadLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	letters := []*DeadLetter{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		letter := &DeadLetter{}
+		if err = json.Unmarshal(scanner.Bytes(), letter); err != nil {
+			t.Fatal(err)
+		}
+		letters = append(letters, letter)
+	}
+
+	// A client error is not retried
+	if len(letters) != 1 || letters[0].URL != rejecting.URL {
+		t.Fatalf("got %d dead letters, want the rejected delivery", len(letters))
+	}
+	payload := &WebhookPayload{}
+	if err = json.Unmarshal(letters[0].Payload, payload); err != nil || len(payload.Events) != 1 || payload.Events[0].User.ID != "U1" {
+		t.Fatalf("the dead letter does not hold the payload: %s", letters[0].Payload)
+	}
+}
+
+func TestSendWebhooksStopsRetryingAtTheWindow(t *testing.T) {
+	webhookBackoff, webhookRetryWindow = 100*time.Millisecond, 150*time.Millisecond
+	t.Cleanup(func() { webhookBackoff, webhookRetryWindow = time.Second, time.Minute })
+
+	attempts := 0
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	notifier := &WebhookNotifier{
+		URLs:       []string{down.URL, down.URL},
+		Retries:    10,
+		DeadLetter: filepath.Join(t.TempDir(), "webhook.deadletter"),
+	}
+	err := notifier.Notify(&Report{Events: []*Event{}})
+
+	// The first delivery is retried once, the second starts too late for a retry
+	if err == nil || attempts != 3 {
+		t.Fatalf("got %d attempts and %v, want retries to stop at the window", attempts, err)
+	}
+}
@@ -0,0 +1,18 @@
+// Package cache writes the member, channel and status files kept between
+// runs of SlackRollCall and SlackChannelMonitor.
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// Write replaces fileName with contents. They are written aside and renamed
+// so readers such as the dashboard never see half a file.
+func Write(fileName string, contents []byte) error {
+	if err := ioutil.WriteFile(fileName+".tmp", contents, 0644); err != nil {
+		os.Remove(fileName + ".tmp")
+		return err
+	}
+	return os.Rename(fileName+".tmp", fileName)
+}
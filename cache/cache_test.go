@@ -0,0 +1,28 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "channelList.cache")
+	for _, contents := range []string{`{"ok":true,"channels":[]}`, `{"ok":true}`} {
+		if err := Write(fileName, []byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+		written, err := ioutil.ReadFile(fileName)
+		if err != nil || string(written) != contents {
+			t.Fatalf("got %q and %v, want %q", written, err, contents)
+		}
+	}
+	if _, err := os.Stat(fileName + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("the file written aside was left behind: %v", err)
+	}
+
+	if err := Write(filepath.Join(t.TempDir(), "missing", "channelList.cache"), []byte("{}")); err == nil {
+		t.Error("a write to a missing directory succeeded")
+	}
+}
@@ -0,0 +1,243 @@
+// Package conversations loads and compares Slack channel lists for
+// SlackRollCall and SlackChannelMonitor.
+package conversations
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/yepher/SlackRollCall/logging"
+)
+
+/**
+Conversation List: https://api.slack.com/methods/conversations.list:
+	Example: https://slack.com/api/conversations.list
+**/
+
+// APIURL is where the Slack Web API is called, replaced by a stand-in in tests
+var APIURL = "https://slack.com/api/"
+
+// Change types found by Diff
+const (
+	Created    = "channel_created"
+	Removed    = "channel_removed"
+	Archived   = "channel_archived"
+	Unarchived = "channel_unarchived"
+	Renamed    = "channel_renamed"
+)
+
+// Channel contains all the information of a channel
+type Channel struct {
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	IsChannel      bool     `json:"is_channel"`
+	Created        int      `json:"created"`
+	Creator        string   `json:"creator"`
+	IsArchived     bool     `json:"is_archived"`
+	IsGeneral      bool     `json:"is_general"`
+	NameNormalized string   `json:"name_normalized"`
+	IsShared       bool     `json:"is_shared"`
+	IsOrgShared    bool     `json:"is_org_shared"`
+	IsMember       bool     `json:"is_member"`
+	IsPrivate      bool     `json:"is_private"`
+	IsMpim         bool     `json:"is_mpim"`
+	Members        []string `json:"members"`
+	Topic          struct {
+		Value   string `json:"value"`
+		Creator string `json:"creator"`
+		LastSet int    `json:"last_set"`
+	} `json:"topic"`
+	Purpose struct {
+		Value   string `json:"value"`
+		Creator string `json:"creator"`
+		LastSet int    `json:"last_set"`
+	} `json:"purpose"`
+	PreviousNames []interface{} `json:"previous_names"`
+	NumMembers    int           `json:"num_members"`
+}
+
+// List is a page of conversations.list results or a cached channel list
+type List struct {
+	Ok               bool       `json:"ok"`
+	Channels         []*Channel `json:"channels,omitempty"`
+	CacheTimestamp   uint64     `json:"cache_ts"`
+	ResponseMetadata struct {
+		NextCursor string `json:"next_cursor"`
+	} `json:"response_metadata"`
+}
+
+// Change is a difference between two channel lists. Previous is the
+// earlier record of a renamed channel.
+type Change struct {
+	Type     string
+	Channel  *Channel
+	Previous *Channel
+}
+
+// Diff returns the changes between two channel lists, leaving out channels
+// whose names start with one of the ignored prefixes. Only public channels
+// are compared, so private channel names stay out of the reports.
+func Diff(previousList *List, currentList *List, ignored []string) []*Change {
+	changes := []*Change{}
+
+	for _, element := range previousList.Channels {
+		if element.IsPrivate {
+			continue
+		}
+
+		current := Find(element.ID, currentList)
+		if current == nil || current.IsPrivate {
+			// Archived channels that drop out were deleted, they were reported when archived
+			if !IsIgnored(element, ignored) && !element.IsArchived {
+				changes = append(changes, &Change{Type: Removed, Channel: element})
+			}
+		} else if current.IsArchived != element.IsArchived {
+			changeType := Unarchived
+			if current.IsArchived {
+				changeType = Archived
+			}
+
+			if !IsIgnored(element, ignored) {
+				changes = append(changes, &Change{Type: changeType, Channel: current})
+			}
+		} else if current.Name != element.Name && !IsIgnored(current, ignored) {
+			changes = append(changes, &Change{Type: Renamed, Channel: current, Previous: element})
+		}
+	}
+
+	for _, element := range currentList.Channels {
+		// Archived channels missing from a cache written before they were
+		// listed are not new
+		if element.IsPrivate || element.IsArchived {
+			continue
+		}
+		if previous := Find(element.ID, previousList); (previous == nil || previous.IsPrivate) && !IsIgnored(element, ignored) {
+			changes = append(changes, &Change{Type: Created, Channel: element})
+		}
+	}
+
+	return changes
+}
+
+// IsIgnored reports whether the channel has no name or starts with one of
+// the prefixes
+func IsIgnored(element *Channel, prefixes []string) bool {
+	var name = element.Name
+	if len(name) == 0 {
+		return true
+	}
+
+	for _, word := range prefixes {
+		if strings.HasPrefix(name, word) {
+			slog.Debug("ignoring channel", "name", name)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Description is the quoted purpose, or topic, of the channel
+func Description(element *Channel) string {
+	description := ""
+	if element.Purpose.Value != "" {
+		description = "`" + element.Purpose.Value + "`"
+	} else if element.Topic.Value != "" {
+		description = "`" + element.Topic.Value + "`"
+	}
+	return description
+}
+
+// LoadFromFile reads a cached channel list, nil when there is none
+func LoadFromFile(fileName string) *List {
+
+	file, e := ioutil.ReadFile(fileName)
+	if e != nil {
+		logging.ReadError(e)
+		return nil
+	}
+
+	var channels *List
+	json.Unmarshal(file, &channels)
+
+	return channels
+}
+
+// Find returns the channel with the ID, nil when it is not in the list
+func Find(id string, channels *List) *Channel {
+	for _, element := range channels.Channels {
+		if element.ID == id {
+			return element
+		}
+	}
+
+	return nil
+}
+
+func loadAsJSON(apiKey string, cursor string) ([]byte, error) {
+	url := APIURL + "conversations.list?exclude_archived=false&types=public_channel,private_channel"
+
+	if len(cursor) > 0 {
+		url = url + "&cursor=" + cursor
+	}
+	slog.Debug("loading channels", "url", url)
+
+	client := &http.Client{}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", "Bearer "+apiKey)
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	return ioutil.ReadAll(response.Body)
+}
+
+// Load fetches every page of the channel list, with the private channels the
+// token can see and archived channels
+func Load(apiKey string) (*List, error) {
+	var cursor = ""
+	pageNum := 1
+	contents, err := loadAsJSON(apiKey, cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	var channels *List
+	json.Unmarshal(contents, &channels)
+
+	if channels == nil || !channels.Ok {
+		return nil, fmt.Errorf("current list failed: %#v", channels)
+	}
+
+	cursor = channels.ResponseMetadata.NextCursor
+	slog.Debug("loaded channels page", "page", pageNum, "next_cursor", cursor)
+
+	for len(cursor) > 0 {
+		pageNum = pageNum + 1
+		data, err := loadAsJSON(apiKey, cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		var nextPage *List
+		json.Unmarshal(data, &nextPage)
+		if nextPage == nil || !nextPage.Ok {
+			return nil, fmt.Errorf("failed to load conversation list from server: %#v", nextPage)
+		}
+
+		channels.Channels = append(channels.Channels, nextPage.Channels...)
+		cursor = nextPage.ResponseMetadata.NextCursor
+		slog.Debug("loaded channels page", "page", pageNum, "next_cursor", cursor)
+	}
+
+	return channels, nil
+}
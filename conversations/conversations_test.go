@@ -0,0 +1,44 @@
+package conversations
+
+import "testing"
+
+func TestDiff(t *testing.T) {
+	channel := func(id string, name string, private bool, archived bool) *Channel {
+		return &Channel{ID: id, Name: name, IsPrivate: private, IsArchived: archived}
+	}
+
+	tests := []struct {
+		name     string
+		previous []*Channel
+		current  []*Channel
+		changes  []string
+	}{
+		{"unchanged", []*Channel{channel("C1", "general", false, false)}, []*Channel{channel("C1", "general", false, false)}, nil},
+		{"created", nil, []*Channel{channel("C1", "general", false, false)}, []string{Created}},
+		{"removed", []*Channel{channel("C1", "general", false, false)}, nil, []string{Removed}},
+		{"renamed", []*Channel{channel("C1", "general", false, false)}, []*Channel{channel("C1", "main", false, false)}, []string{Renamed}},
+		{"archived", []*Channel{channel("C1", "general", false, false)}, []*Channel{channel("C1", "general", false, true)}, []string{Archived}},
+		{"unarchived", []*Channel{channel("C1", "general", false, true)}, []*Channel{channel("C1", "general", false, false)}, []string{Unarchived}},
+		{"archived then deleted", []*Channel{channel("C1", "general", false, true)}, nil, nil},
+		// A cache written before archived channels were listed
+		{"archived before the cache", nil, []*Channel{channel("C1", "general", false, true)}, nil},
+		{"ignored", nil, []*Channel{channel("C1", "tmp-general", false, false)}, nil},
+		{"private created", nil, []*Channel{channel("C1", "secret", true, false)}, nil},
+		{"private renamed", []*Channel{channel("C1", "secret", true, false)}, []*Channel{channel("C1", "hidden", true, false)}, nil},
+		{"made private", []*Channel{channel("C1", "general", false, false)}, []*Channel{channel("C1", "general", true, false)}, []string{Removed}},
+		{"made public", []*Channel{channel("C1", "general", true, false)}, []*Channel{channel("C1", "general", false, false)}, []string{Created}},
+	}
+
+	for _, test := range tests {
+		changes := Diff(&List{Channels: test.previous}, &List{Channels: test.current}, []string{"tmp-"})
+		if len(changes) != len(test.changes) {
+			t.Errorf("%s: got %d changes, want %v", test.name, len(changes), test.changes)
+			continue
+		}
+		for i, change := range changes {
+			if change.Type != test.changes[i] {
+				t.Errorf("%s: got %s, want %s", test.name, change.Type, test.changes[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Package logging sets up the leveled structured logger shared by
+// SlackRollCall and SlackChannelMonitor.
+//
+// Diagnostics go to stderr so stdout only carries the report. The logger is
+// installed as the slog default, so callers log with slog.Info, slog.Debug
+// and so on.
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// New returns a logger writing to stderr in format (text or json) that
+// drops messages below level (debug, info, warn or error)
+func New(format string, level string) (*slog.Logger, error) {
+	var threshold slog.Level
+	if err := threshold.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("--loglevel must be debug, info, warn or error")
+	}
+
+	options := &slog.HandlerOptions{Level: threshold}
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, options)), nil
+	default:
+		return nil, fmt.Errorf("--logformat must be text or json")
+	}
+}
+
+// Setup installs a logger made by New as the slog default
+func Setup(format string, level string) error {
+	logger, err := New(format, level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// ReadError logs a failed cache read, a missing file is expected on the
+// first run
+func ReadError(err error) {
+	if os.IsNotExist(err) {
+		slog.Debug("file not found", "error", err)
+		return
+	}
+	slog.Warn("file error", "error", err)
+}
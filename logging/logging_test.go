@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		format  string
+		level   string
+		valid   bool
+		lowest  slog.Level
+		dropped slog.Level
+	}{
+		{"text", "info", true, slog.LevelInfo, slog.LevelDebug},
+		{"JSON", "debug", true, slog.LevelDebug, slog.LevelDebug - 1},
+		{"json", "WARN", true, slog.LevelWarn, slog.LevelInfo},
+		{"text", "error", true, slog.LevelError, slog.LevelWarn},
+		{"xml", "info", false, 0, 0},
+		{"text", "loud", false, 0, 0},
+		{"text", "", false, 0, 0},
+	}
+
+	for _, test := range tests {
+		logger, err := New(test.format, test.level)
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("New(%q, %q): got %v", test.format, test.level, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if !logger.Enabled(context.Background(), test.lowest) || logger.Enabled(context.Background(), test.dropped) {
+			t.Errorf("New(%q, %q) does not start logging at %s", test.format, test.level, test.lowest)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+// Package options parses the flag values shared by SlackRollCall and
+// SlackChannelMonitor.
+package options
+
+import "strings"
+
+// List splits a comma separated flag value, trimming spaces and dropping
+// empty entries
+func List(value string) []string {
+	entries := []string{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,27 @@
+package options
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", []string{}},
+		{"a@example.com", []string{"a@example.com"}},
+		{"a@example.com, b@example.com", []string{"a@example.com", "b@example.com"}},
+		{" https://one.example/hook ,https://two.example/hook\t", []string{"https://one.example/hook", "https://two.example/hook"}},
+		{"a,,b,", []string{"a", "b"}},
+		{" , ", []string{}},
+	}
+
+	for _, test := range tests {
+		got := List(test.value)
+		if strings.Join(got, "|") != strings.Join(test.want, "|") || len(got) != len(test.want) {
+			t.Errorf("List(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Package schedule runs a check on an interval or a cron schedule for the
+// --watch mode of SlackRollCall and SlackChannelMonitor.
+package schedule
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+/**
+Watch mode.
+
+	With --watch the check runs at start up and then on a schedule until
+	SIGINT or SIGTERM, instead of once. The schedule is either an interval
+	between the end of one run and the start of the next (15m, 1h) or a cron
+	expression with minute, hour, day of month, month and day of week fields
+	(0-59/15 * * * *, 0 9 * * 1-5) or @hourly, @daily, @weekly and @monthly.
+
+	Runs never overlap: a run that takes longer than the schedule skips the
+	missed times, and a signal received during a run stops the watch once
+	the run has finished.
+**/
+
+// Schedule returns the next time a check should run
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+type intervalSchedule struct {
+	interval time.Duration
+}
+
+// cronSchedule holds a bit per allowed value of every field
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays uint64
+	anyDay, anyWeekday                     bool
+}
+
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// Parse parses an interval or a cron expression
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if interval, err := time.ParseDuration(spec); err == nil {
+		if interval <= 0 {
+			return nil, fmt.Errorf("invalid interval %q", spec)
+		}
+		return &intervalSchedule{interval}, nil
+	}
+
+	if alias, ok := cronAliases[spec]; ok {
+		spec = alias
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q, expected an interval or five cron fields", spec)
+	}
+
+	schedule := &cronSchedule{
+		anyDay:     strings.HasPrefix(fields[2], "*"),
+		anyWeekday: strings.HasPrefix(fields[4], "*"),
+	}
+
+	var err error
+	for i, field := range []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&schedule.minutes, 0, 59},
+		{&schedule.hours, 0, 23},
+		{&schedule.days, 1, 31},
+		{&schedule.months, 1, 12},
+		{&schedule.weekdays, 0, 7},
+	} {
+		if *field.bits, err = parseCronField(fields[i], field.min, field.max); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v", spec, err)
+		}
+	}
+
+	// Sunday is both 0 and 7
+	if schedule.weekdays&(1<<7) != 0 {
+		schedule.weekdays |= 1
+	}
+
+	return schedule, nil
+}
+
+// parseCronField parses a comma separated list of *, values, ranges and steps
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if slash := strings.Index(part, "/"); slash >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[slash+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:slash]
+		}
+
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if step > 1 {
+				high = max
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+// Next returns the interval after the given time
+func (schedule *intervalSchedule) Next(after time.Time) time.Time {
+	return after.Add(schedule.interval)
+}
+
+// Next returns the first minute after the given time matching every field
+func (schedule *cronSchedule) Next(after time.Time) time.Time {
+	next := after.Truncate(time.Minute).Add(time.Minute)
+	limit := next.AddDate(5, 0, 0)
+
+	for next.Before(limit) {
+		switch {
+		case schedule.months&(1<<uint(next.Month())) == 0:
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+		case !schedule.dayMatches(next):
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+		case schedule.hours&(1<<uint(next.Hour())) == 0:
+			next = next.Truncate(time.Hour).Add(time.Hour)
+		case schedule.minutes&(1<<uint(next.Minute())) == 0:
+			next = next.Add(time.Minute)
+		default:
+			return next
+		}
+	}
+
+	return limit
+}
+
+// dayMatches applies cron's rule that a restricted day of month and day of
+// week match when either does
+func (schedule *cronSchedule) dayMatches(day time.Time) bool {
+	dayOk := schedule.days&(1<<uint(day.Day())) != 0
+	weekdayOk := schedule.weekdays&(1<<uint(day.Weekday())) != 0
+
+	if schedule.anyDay || schedule.anyWeekday {
+		return dayOk && weekdayOk
+	}
+	return dayOk || weekdayOk
+}
+
+// Watch runs the check now and then on the schedule until SIGINT or SIGTERM
+func Watch(schedule Schedule, check func() error) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	for {
+		if err := check(); err != nil {
+			slog.Error("run failed", "error", err)
+		}
+
+		next := schedule.Next(time.Now())
+		slog.Info("next run", "at", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case sig := <-stop:
+			timer.Stop()
+			slog.Info("stopping", "signal", sig.String())
+			return
+		case <-timer.C:
+		}
+	}
+}
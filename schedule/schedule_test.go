@@ -0,0 +1,94 @@
+package schedule
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	// A Sunday
+	after := time.Date(2026, 10, 18, 9, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		next string
+	}{
+		{"15m", "2026-10-18T09:22:30Z"},
+		{" 1h ", "2026-10-18T10:07:30Z"},
+		{"*/15 * * * *", "2026-10-18T09:15:00Z"},
+		{"0-59/15 * * * *", "2026-10-18T09:15:00Z"},
+		{"7 * * * *", "2026-10-18T10:07:00Z"},
+		{"0 9 * * 1-5", "2026-10-19T09:00:00Z"},
+		{"0 12 * * 7", "2026-10-18T12:00:00Z"},
+		{"0 12 * * 0", "2026-10-18T12:00:00Z"},
+		{"30 8 1 * *", "2026-11-01T08:30:00Z"},
+		{"0 0 1,15 * *", "2026-11-01T00:00:00Z"},
+		// Day of month and day of week match either, as in cron
+		{"0 0 13 * 5", "2026-10-23T00:00:00Z"},
+		{"0 0 29 2 *", "2028-02-29T00:00:00Z"},
+		{"@hourly", "2026-10-18T10:00:00Z"},
+		{"@daily", "2026-10-19T00:00:00Z"},
+		{"@weekly", "2026-10-25T00:00:00Z"},
+		{"@monthly", "2026-11-01T00:00:00Z"},
+	}
+
+	for _, test := range tests {
+		schedule, err := Parse(test.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.spec, err)
+			continue
+		}
+		if next := schedule.Next(after).Format(time.RFC3339); next != test.next {
+			t.Errorf("%q: next run at %s, want %s", test.spec, next, test.next)
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"-5m",
+		"0s",
+		"* * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@yearly",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) accepted a bad schedule", spec)
+		}
+	}
+}
+
+func TestWatchStopsOnSignal(t *testing.T) {
+	runs := 0
+	stopped := make(chan struct{})
+	go func() {
+		Watch(&intervalSchedule{10 * time.Millisecond}, func() error {
+			if runs++; runs == 3 {
+				// Delivered during the run, so the watch stops once it finishes
+				syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+				time.Sleep(30 * time.Millisecond)
+			}
+			return nil
+		})
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watch did not stop")
+	}
+	if runs != 3 {
+		t.Fatalf("got %d runs, want 3", runs)
+	}
+}